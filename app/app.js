@@ -160,27 +160,131 @@ async function uploadVideoFile(videoID) {
       throw new Error(`Failed to upload video file. Error: ${data.error}`);
     }
 
-    console.log("Video uploaded!");
+    console.log("Video uploaded! Processing...");
+    await watchVideoProgress(videoID);
+    console.log("Video processed!");
     await getVideo(videoID);
   } catch (error) {
     alert(`Error: ${error.message}`);
   }
 }
 
-async function getVideos() {
-  try {
-    const res = await fetch("/api/videos", {
-      method: "GET",
+async function waitForVideoProcessing(videoID) {
+  while (true) {
+    const res = await fetch(`/api/videos/${videoID}/status`, {
       headers: {
         Authorization: `Bearer ${localStorage.getItem("token")}`,
       },
     });
+    const data = await res.json();
     if (!res.ok) {
-      const data = await res.json();
-      throw new Error(`Failed to get videos. Error: ${data.error}`);
+      throw new Error(`Failed to get video status. Error: ${data.error}`);
+    }
+    showVideoStatus(data.status);
+    if (data.status === "ready") {
+      return;
     }
+    if (data.status === "failed") {
+      throw new Error(`Video processing failed: ${data.status_error}`);
+    }
+    await new Promise((resolve) => setTimeout(resolve, 2000));
+  }
+}
+
+// watchVideoProgress follows the live progress stream until processing finishes,
+// falling back to polling the status endpoint if the stream can't be used.
+async function watchVideoProgress(videoID) {
+  // EventSource can't send the JWT, so it opens the signed URL the status endpoint hands out
+  const res = await fetch(`/api/videos/${videoID}/status`, {
+    headers: {
+      Authorization: `Bearer ${localStorage.getItem("token")}`,
+    },
+  });
+  const status = await res.json();
+  if (!res.ok) {
+    throw new Error(`Failed to get video status. Error: ${status.error}`);
+  }
+
+  return new Promise((resolve, reject) => {
+    const source = new EventSource(status.events_url);
+    let finished = false;
+
+    source.addEventListener("progress", (e) => {
+      const event = JSON.parse(e.data);
+      showVideoProgress(event);
+      if (!event.done) {
+        return;
+      }
+      finished = true;
+      source.close();
+      if (event.stage === "failed") {
+        reject(new Error(`Video processing failed: ${event.error}`));
+      } else {
+        resolve();
+      }
+    });
+
+    source.onerror = () => {
+      if (finished) {
+        return;
+      }
+      finished = true;
+      source.close();
+      waitForVideoProcessing(videoID).then(resolve, reject);
+    };
+  });
+}
+
+function showVideoProgress(event) {
+  showVideoStatus(event.stage);
+  const progressBar = document.getElementById("video-progress");
+  if (!progressBar) {
+    return;
+  }
+  progressBar.hidden = event.done;
+  progressBar.value = event.percent;
+
+  const statusDisplay = document.getElementById("video-status-display");
+  if (statusDisplay && !event.done) {
+    let text = `Status: ${event.stage} ${Math.round(event.percent)}%`;
+    if (event.eta_seconds !== null) {
+      text += ` (about ${Math.ceil(event.eta_seconds)}s left)`;
+    }
+    statusDisplay.textContent = text;
+  }
+}
+
+function showVideoStatus(status) {
+  const statusDisplay = document.getElementById("video-status-display");
+  if (statusDisplay) {
+    statusDisplay.textContent = `Status: ${status}`;
+  }
+}
+
+async function getVideos() {
+  try {
+    // The list is paginated, follow the cursors until the last page
+    const videos = [];
+    let cursor = "";
+    do {
+      const params = new URLSearchParams({ limit: "100" });
+      if (cursor) {
+        params.set("cursor", cursor);
+      }
+      const res = await fetch(`/api/videos?${params}`, {
+        method: "GET",
+        headers: {
+          Authorization: `Bearer ${localStorage.getItem("token")}`,
+        },
+      });
+      const data = await res.json();
+      if (!res.ok) {
+        throw new Error(`Failed to get videos. Error: ${data.error}`);
+      }
+      videos.push(...data.videos);
+      cursor = data.next_cursor;
+    } while (cursor);
 
-    const videos = await res.json();
     const videoList = document.getElementById("video-list");
     videoList.innerHTML = "";
     for (const video of videos) {
@@ -221,6 +325,7 @@ function viewVideo(video) {
   document.getElementById("video-title-display").textContent = video.title;
   document.getElementById("video-description-display").textContent =
     video.description;
+  showVideoStatus(video.status);
 
   const thumbnailImg = document.getElementById("thumbnail-image");
   if (!video.thumbnail_url) {
@@ -229,6 +334,10 @@ function viewVideo(video) {
     thumbnailImg.style.display = "block";
     // thumbnailImg.src = `${video.thumbnail_url}?v=${Date.now()}`;
     thumbnailImg.src = video.thumbnail_url;
+    // Let the browser pick the smallest variant that fits
+    thumbnailImg.srcset = Object.entries(video.thumbnails || {})
+      .map(([width, url]) => `${url} ${width}w`)
+      .join(", ");
   }
 
   const downloadButton = document.getElementById("download-button");
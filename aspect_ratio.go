@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"math"
+)
+
+// aspectRatioTolerance is how far (relative) a video can be from a bucket and still
+// land in it. Encoders round dimensions to multiples of 2 or 16, so exact
+// matches are the exception.
+const aspectRatioTolerance = 0.03
+
+// aspectRatioBuckets are the ratios videos are sorted into, each with the
+// directory its videos are stored under
+var aspectRatioBuckets = []struct {
+	name      string
+	ratio     float64
+	directory string
+}{
+	{"16:9", 16.0 / 9.0, "landscape"},
+	{"9:16", 9.0 / 16.0, "portrait"},
+	{"1:1", 1, "square"},
+	{"4:3", 4.0 / 3.0, "standard"},
+	{"3:4", 3.0 / 4.0, "standard-portrait"},
+	{"21:9", 21.0 / 9.0, "ultrawide"},
+}
+
+// classifyAspectRatio returns the closest bucket within tolerance, or "other"
+func classifyAspectRatio(width, height int) string {
+	if width <= 0 || height <= 0 {
+		return "other"
+	}
+	ratio := float64(width) / float64(height)
+
+	best, bestDiff := "other", aspectRatioTolerance
+	for _, b := range aspectRatioBuckets {
+		diff := math.Abs(ratio-b.ratio) / b.ratio
+		if diff <= bestDiff {
+			best, bestDiff = b.name, diff
+		}
+	}
+	return best
+}
+
+// aspectRatioDirectory is the key prefix for videos of an aspect ratio bucket
+func aspectRatioDirectory(aspectRatio string) string {
+	for _, b := range aspectRatioBuckets {
+		if b.name == aspectRatio {
+			return b.directory
+		}
+	}
+	return "other"
+}
+
+// displayDimensions is the size the stream is shown at: stretched by its
+// sample aspect ratio and turned by its rotation
+func (s ffprobeStream) displayDimensions() (int, int) {
+	width, height := s.Width, s.Height
+	if sar, ok := parseRational(s.SampleAspectRatio); ok && sar != 1 {
+		width = int(math.Round(float64(width) * sar))
+	}
+	if rotation := s.rotation(); rotation == 90 || rotation == 270 {
+		width, height = height, width
+	}
+	return width, height
+}
+
+// getVideoDimensions returns the display width and height of the first video stream
+func getVideoDimensions(filePath string) (int, int, error) {
+	probe, err := probeVideo(filePath)
+	if err != nil {
+		return 0, 0, err
+	}
+	stream, ok := probe.videoStream()
+	if !ok {
+		return 0, 0, errors.New("no video streams found")
+	}
+	width, height := stream.displayDimensions()
+	return width, height, nil
+}
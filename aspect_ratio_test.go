@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestClassifyAspectRatio(t *testing.T) {
+	tests := []struct {
+		width, height int
+		want          string
+	}{
+		{1920, 1080, "16:9"},
+		{1080, 1920, "9:16"},
+		// 16:9 rounded to a multiple of 16
+		{854, 480, "16:9"},
+		{1920, 1088, "16:9"},
+		{720, 720, "1:1"},
+		{640, 480, "4:3"},
+		{480, 640, "3:4"},
+		{2560, 1080, "21:9"},
+		// 2.39:1 scope is close enough to 21:9, 1.85:1 flat is too far from 16:9
+		{1920, 804, "21:9"},
+		{1998, 1080, "other"},
+		{1000, 100, "other"},
+		{0, 1080, "other"},
+		{1920, -1, "other"},
+	}
+	for _, tt := range tests {
+		if got := classifyAspectRatio(tt.width, tt.height); got != tt.want {
+			t.Errorf("classifyAspectRatio(%d, %d) = %q, want %q", tt.width, tt.height, got, tt.want)
+		}
+	}
+}
+
+func TestAspectRatioDirectory(t *testing.T) {
+	for ratio, want := range map[string]string{
+		"16:9":  "landscape",
+		"9:16":  "portrait",
+		"1:1":   "square",
+		"other": "other",
+		"":      "other",
+	} {
+		if got := aspectRatioDirectory(ratio); got != want {
+			t.Errorf("aspectRatioDirectory(%q) = %q, want %q", ratio, got, want)
+		}
+	}
+}
+
+func TestDisplayDimensions(t *testing.T) {
+	tests := []struct {
+		name          string
+		stream        string
+		width, height int
+	}{
+		{"plain", `{"width": 1920, "height": 1080}`, 1920, 1080},
+		{"square pixels", `{"width": 1920, "height": 1080, "sample_aspect_ratio": "1:1"}`, 1920, 1080},
+		{"anamorphic", `{"width": 1440, "height": 1080, "sample_aspect_ratio": "4:3"}`, 1920, 1080},
+		{"rotate tag", `{"width": 1920, "height": 1080, "tags": {"rotate": "90"}}`, 1080, 1920},
+		{"display matrix", `{"width": 1920, "height": 1080, "side_data_list": [{"rotation": -90}]}`, 1080, 1920},
+		{"upside down", `{"width": 1920, "height": 1080, "side_data_list": [{"rotation": 180}]}`, 1920, 1080},
+		{"anamorphic portrait", `{"width": 1440, "height": 1080, "sample_aspect_ratio": "4:3", "tags": {"rotate": "270"}}`, 1080, 1920},
+	}
+	for _, tt := range tests {
+		var stream ffprobeStream
+		if err := json.Unmarshal([]byte(tt.stream), &stream); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		width, height := stream.displayDimensions()
+		if width != tt.width || height != tt.height {
+			t.Errorf("%s: displayDimensions() = %dx%d, want %dx%d", tt.name, width, height, tt.width, tt.height)
+		}
+	}
+}
@@ -3,10 +3,15 @@ package main
 import (
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
-	"path/filepath"
+	"strconv"
 	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
 )
 
 func (cfg apiConfig) ensureAssetsDir() error {
@@ -37,12 +42,33 @@ func (cfg apiConfig) getObjectURL(key string) string {
 	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", cfg.s3Bucket, cfg.s3Region, key)
 }
 
-func (cfg apiConfig) getAssetDiskPath(assetPath string) string {
-	return filepath.Join(cfg.assetsRoot, assetPath)
+func (cfg apiConfig) getAssetURL(assetPath string) string {
+	return cfg.assetStore.URL(assetPath)
 }
 
-func (cfg apiConfig) getAssetURL(assetPath string) string {
-	return fmt.Sprintf("http://localhost:%s/assets/%s", cfg.port, assetPath)
+// handlerStoredAsset serves /assets/ out of the blob stores when there is
+// no directory on disk to point a http.FileServer at (the memory backend).
+func (cfg *apiConfig) handlerStoredAsset(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Path
+	for _, store := range []storage.BlobStore{cfg.assetStore, cfg.videoStore} {
+		body, info, err := store.Get(r.Context(), key)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't read asset", err)
+			return
+		}
+		defer body.Close()
+
+		if info.ContentType != "" {
+			w.Header().Set("Content-Type", info.ContentType)
+		}
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+		io.Copy(w, body)
+		return
+	}
+	http.NotFound(w, r)
 }
 
 func mediaTypeToExt(mediaType string) string {
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// packageDASH transcodes the video into the same ladder as packageHLS, muxed as
+// fragmented MP4 segments with a single .mpd manifest. Everything is uploaded
+// under <keyPrefix>/dash/ and the manifest URL is returned.
+func (cfg *apiConfig) packageDASH(ctx context.Context, filePath, keyPrefix string, duration time.Duration, onProgress func(float64)) (string, error) {
+	width, height, err := getVideoDimensions(filePath)
+	if err != nil {
+		return "", err
+	}
+	hasAudio, err := hasAudioStream(filePath)
+	if err != nil {
+		return "", err
+	}
+
+	outputDir, err := os.MkdirTemp("", "tubely-dash-")
+	if err != nil {
+		return "", fmt.Errorf("couldn't create DASH output directory: %w", err)
+	}
+	defer os.RemoveAll(outputDir)
+
+	renditions := renditionsFor(width, height)
+
+	// One ffmpeg run encodes every rendition so they share segment boundaries
+	args := []string{"-i", filePath}
+	for range renditions {
+		args = append(args, "-map", "0:v:0")
+	}
+	if hasAudio {
+		args = append(args, "-map", "0:a:0")
+	}
+	args = append(args,
+		"-c:v", "libx264",
+		"-preset", "veryfast",
+		"-profile:v", "main",
+		"-force_key_frames", "expr:gte(t,n_forced*2)",
+		"-sc_threshold", "0",
+	)
+	for i, r := range renditions {
+		outWidth, outHeight := r.scaledDimensions(width, height)
+		args = append(args,
+			fmt.Sprintf("-filter:v:%d", i), fmt.Sprintf("scale=%d:%d", outWidth, outHeight),
+			fmt.Sprintf("-b:v:%d", i), fmt.Sprintf("%dk", r.videoBitrate),
+			fmt.Sprintf("-maxrate:v:%d", i), fmt.Sprintf("%dk", r.videoBitrate*107/100),
+			fmt.Sprintf("-bufsize:v:%d", i), fmt.Sprintf("%dk", r.videoBitrate*3/2),
+		)
+	}
+	adaptationSets := "id=0,streams=v"
+	if hasAudio {
+		args = append(args, "-c:a", "aac", "-b:a", "128k", "-ac", "2")
+		adaptationSets += " id=1,streams=a"
+	}
+	args = append(args,
+		"-f", "dash",
+		"-seg_duration", "4",
+		"-use_template", "1",
+		"-use_timeline", "1",
+		"-init_seg_name", "init-$RepresentationID$.m4s",
+		"-media_seg_name", "chunk-$RepresentationID$-$Number%05d$.m4s",
+		"-adaptation_sets", adaptationSets,
+		filepath.Join(outputDir, "manifest.mpd"),
+	)
+
+	if err := runFFmpeg(ctx, duration, onProgress, args...); err != nil {
+		return "", fmt.Errorf("error packaging DASH: %v", err)
+	}
+
+	dashPrefix := path.Join(keyPrefix, "dash")
+	if err := cfg.uploadDirectory(ctx, outputDir, dashPrefix); err != nil {
+		return "", err
+	}
+	return cfg.videoStore.URL(path.Join(dashPrefix, "manifest.mpd")), nil
+}
+
+func hasAudioStream(filePath string) (bool, error) {
+	cmd := exec.Command("ffprobe",
+		"-v", "error",
+		"-select_streams", "a",
+		"-show_entries", "stream=index",
+		"-of", "csv=p=0",
+		filePath,
+	)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		return false, fmt.Errorf("ffprobe error: %v", err)
+	}
+	return strings.TrimSpace(stdout.String()) != "", nil
+}
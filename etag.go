@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// videoETag identifies a version of a video's metadata. updated_at changes on
+// every edit, so it's all the ETag needs.
+func videoETag(video database.Video) string {
+	return `"` + strconv.FormatInt(video.UpdatedAt.UnixNano(), 36) + `"`
+}
+
+// signedVideoETag is videoETag for a response with signed URLs in it. It also
+// names the window, half of ttl long, the response was made in: revalidating
+// in the same window gets a 304 and keeps URLs that still work for at least
+// half their TTL, a later request gets freshly signed ones.
+func signedVideoETag(video database.Video, ttl time.Duration, now time.Time) string {
+	window := max(ttl/2, time.Second)
+	return strings.TrimSuffix(videoETag(video), `"`) + "." + strconv.FormatInt(now.UnixNano()/int64(window), 36) + `"`
+}
+
+// signingWindow matches the window signedVideoETag adds, base 36 has no dots
+var signingWindow = regexp.MustCompile(`\.[0-9a-z]+"`)
+
+// withoutSigningWindow drops the signing windows from the ETags in an If-Match
+// header, an edit only cares about the version
+func withoutSigningWindow(header string) string {
+	return signingWindow.ReplaceAllString(header, `"`)
+}
+
+// etagMatches reports whether an If-Match or If-None-Match header value lists etag.
+// Weak validators compare equal to strong ones, as If-None-Match requires.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified reports whether a conditional GET already has the current version.
+// If-None-Match wins over If-Modified-Since when both are sent.
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		return etagMatches(ifNoneMatch, etag)
+	}
+	ifModifiedSince, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	// HTTP dates only have whole seconds
+	return !lastModified.Truncate(time.Second).After(ifModifiedSince)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestVideoETagChangesWithUpdatedAt(t *testing.T) {
+	updatedAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	video := database.Video{UpdatedAt: updatedAt}
+	etag := videoETag(video)
+	if etag != videoETag(video) {
+		t.Fatalf("videoETag isn't stable")
+	}
+	video.UpdatedAt = updatedAt.Add(time.Millisecond)
+	if videoETag(video) == etag {
+		t.Errorf("videoETag didn't change when updated_at moved by a millisecond")
+	}
+}
+
+func TestETagMatches(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{`"abc"`, true},
+		{`W/"abc"`, true},
+		{`"xyz", "abc"`, true},
+		{`*`, true},
+		{`"abcd"`, false},
+		{`abc`, false},
+		{``, false},
+	}
+	for _, tt := range tests {
+		if got := etagMatches(tt.header, `"abc"`); got != tt.want {
+			t.Errorf("etagMatches(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	lastModified := time.Date(2024, 3, 1, 12, 0, 0, 500_000_000, time.UTC)
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    bool
+	}{
+		{"unconditional", nil, false},
+		{"matching etag", map[string]string{"If-None-Match": `"v1"`}, true},
+		{"stale etag", map[string]string{"If-None-Match": `"v0"`}, false},
+		{"same second", map[string]string{"If-Modified-Since": "Fri, 01 Mar 2024 12:00:00 GMT"}, true},
+		{"a second earlier", map[string]string{"If-Modified-Since": "Fri, 01 Mar 2024 11:59:59 GMT"}, false},
+		{"bad date", map[string]string{"If-Modified-Since": "yesterday"}, false},
+		{"etag wins over date", map[string]string{
+			"If-None-Match":     `"v0"`,
+			"If-Modified-Since": "Fri, 01 Mar 2024 12:00:00 GMT",
+		}, false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/api/videos/1", nil)
+		for k, v := range tt.headers {
+			r.Header.Set(k, v)
+		}
+		if got := notModified(r, `"v1"`, lastModified); got != tt.want {
+			t.Errorf("%s: notModified = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSignedVideoETag(t *testing.T) {
+	video := database.Video{UpdatedAt: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+	ttl := time.Hour
+	windowStart := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC).Truncate(ttl / 2)
+
+	etag := signedVideoETag(video, ttl, windowStart)
+	if got := signedVideoETag(video, ttl, windowStart.Add(ttl/2-time.Nanosecond)); got != etag {
+		t.Errorf("ETag changed within the signing window: %s, then %s", etag, got)
+	}
+	if got := signedVideoETag(video, ttl, windowStart.Add(ttl/2)); got == etag {
+		t.Errorf("ETag stayed %s in the next signing window", etag)
+	}
+	edited := video
+	edited.UpdatedAt = video.UpdatedAt.Add(time.Millisecond)
+	if got := signedVideoETag(edited, ttl, windowStart); got == etag {
+		t.Errorf("ETag stayed %s after an edit", etag)
+	}
+	// Windows are never shorter than a second
+	if signedVideoETag(video, time.Millisecond, windowStart) != signedVideoETag(video, time.Millisecond, windowStart.Add(500*time.Millisecond)) {
+		t.Errorf("a tiny TTL got a window shorter than a second")
+	}
+
+	if got := withoutSigningWindow(etag); got != videoETag(video) {
+		t.Errorf("withoutSigningWindow(%s) = %s, want the version %s", etag, got, videoETag(video))
+	}
+	header := etag + `, W/` + signedVideoETag(edited, ttl, windowStart)
+	want := videoETag(video) + `, W/` + videoETag(edited)
+	if got := withoutSigningWindow(header); got != want {
+		t.Errorf("withoutSigningWindow(%s) = %s, want %s", header, got, want)
+	}
+	if got := withoutSigningWindow(videoETag(video)); got != videoETag(video) {
+		t.Errorf("withoutSigningWindow changed an unsigned ETag to %s", got)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runFFmpeg runs ffmpeg with -progress output on stdout and calls onProgress with
+// the fraction of duration encoded so far. onProgress may be nil.
+func runFFmpeg(ctx context.Context, duration time.Duration, onProgress func(float64), args ...string) error {
+	args = append([]string{"-progress", "pipe:1", "-nostats"}, args...)
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("couldn't read ffmpeg progress: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("couldn't start ffmpeg: %v", err)
+	}
+
+	// Progress comes in blocks of key=value lines, out_time_us is how far into the input ffmpeg is
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok || key != "out_time_us" || onProgress == nil || duration <= 0 {
+			continue
+		}
+		us, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		onProgress(float64(us) / float64(duration.Microseconds()))
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("%s, %v", stderr.String(), err)
+	}
+	return nil
+}
+
+// getVideoDuration returns the container duration reported by ffprobe
+func getVideoDuration(filePath string) (time.Duration, error) {
+	cmd := exec.Command("ffprobe",
+		"-v", "error",
+		"-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		filePath,
+	)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		return 0, fmt.Errorf("ffprobe error: %v", err)
+	}
+
+	seconds, err := strconv.ParseFloat(strings.TrimSpace(stdout.String()), 64)
+	if err != nil {
+		// Some containers (e.g. raw streams) have no duration, progress just won't be reported
+		return 0, nil
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
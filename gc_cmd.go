@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// runGCCommand runs the orphaned object reconciler once and prints what it found.
+// With -dry-run nothing is moved or deleted.
+func (cfg *apiConfig) runGCCommand(args []string, defaults orphanGCOptions) error {
+	flags := flag.NewFlagSet("gc", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "only report what would be done")
+	grace := flags.Duration("grace", defaults.GracePeriod, "leave objects younger than this alone")
+	quarantine := flags.Duration("quarantine", defaults.QuarantinePeriod, "delete objects quarantined longer ago than this")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	report, err := cfg.collectOrphans(context.Background(), orphanGCOptions{
+		GracePeriod:      *grace,
+		QuarantinePeriod: *quarantine,
+		DryRun:           *dryRun,
+	})
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STORE\tKEY\tSIZE\tMODIFIED\tACTION")
+	for _, o := range report.Orphans {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", o.Store, o.Key, o.Size, o.LastModified.Local().Format(time.RFC3339), o.label(report.DryRun))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Println(report)
+	return nil
+}
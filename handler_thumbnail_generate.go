@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+const generateThumbnailMaxAttempts = 3
+
+// handlerThumbnailGenerate queues a job that replaces the video's thumbnail with a frame
+// from the stored video. The body is optional and overrides the configured frame choice.
+func (cfg *apiConfig) handlerThumbnailGenerate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		TimestampSeconds *float64 `json:"timestamp_seconds"`
+		SceneDetection   *bool    `json:"scene_detection"`
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	params := parameters{}
+	err = json.NewDecoder(r.Body).Decode(&params)
+	if err != nil && !errors.Is(err, io.EOF) {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	opts := cfg.thumbnailOptions
+	if params.TimestampSeconds != nil {
+		if *params.TimestampSeconds < 0 {
+			respondWithError(w, http.StatusBadRequest, "timestamp_seconds can't be negative", nil)
+			return
+		}
+		opts.Timestamp = time.Duration(*params.TimestampSeconds * float64(time.Second))
+	}
+	if params.SceneDetection != nil {
+		opts.SceneDetection = *params.SceneDetection
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || video.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return
+	}
+	if video.VideoURL == nil {
+		respondWithError(w, http.StatusConflict, "Video has no uploaded file yet", nil)
+		return
+	}
+
+	job, err := cfg.jobs.Enqueue(jobTypeGenerateThumbnail, userID, generateThumbnailPayload{
+		VideoID: video.ID,
+		Options: opts,
+	}, generateThumbnailMaxAttempts)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't queue thumbnail generation", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusAccepted, job)
+}
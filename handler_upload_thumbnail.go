@@ -1,13 +1,13 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"io"
 	"mime"
 	"net/http"
-	"os"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/thumbnail"
 	"github.com/google/uuid"
 )
 
@@ -63,20 +63,43 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	// Use the Content-Type header to determine the file extension
-	// Use the videoID to create a unique file path.
-	assetPath := getAssetPath(mediaType)
-	assetDiskPath := cfg.getAssetDiskPath(assetPath)
+	// Get the video's metadata from the SQLite database.
+	// The apiConfig's db has a GetVideo method you can use
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't find video", err)
+		return
+	}
+	if video.UserID != userID {
+		// If the authenticated user is not the video owner,
+		// return a http.StatusUnauthorized response
+		respondWithError(w, http.StatusUnauthorized, "Not authorized to update this video", nil)
+		return
+	}
+
+	// The declared type is only a claim, check the magic bytes
+	detectedType, err := sniffImageType(file)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't read file", err)
+		return
+	}
+	if err := checkDetectedType(mediaType, detectedType); err != nil {
+		respondWithError(w, http.StatusUnsupportedMediaType, err.Error(), err)
+		return
+	}
 
-	// Use os.Create to create the new file
-	dst, err := os.Create(assetDiskPath)
+	// Decode the image and store resized JPEG variants instead of whatever bytes were sent
+	img, err := thumbnail.Decode(file)
+	if errors.Is(err, thumbnail.ErrTooLarge) {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Image dimensions are too large", err)
+		return
+	}
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Unable to create file on server", err)
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode image", err)
 		return
 	}
-	defer dst.Close()
-	// Copy the contents from the multipart.File to the new file on disk using io.Copy
-	if _, err = io.Copy(dst, file); err != nil {
+	url, thumbnails, err := cfg.storeThumbnailVariants(r.Context(), img)
+	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Error saving file", err)
 		return
 	}
@@ -88,20 +111,6 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 	// 	return
 	// }
 
-	// Get the video's metadata from the SQLite database.
-	// The apiConfig's db has a GetVideo method you can use
-	video, err := cfg.db.GetVideo(videoID)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't find video", err)
-		return
-	}
-	if video.UserID != userID {
-		// If the authenticated user is not the video owner,
-		// return a http.StatusUnauthorized response
-		respondWithError(w, http.StatusUnauthorized, "Not authorized to update this video", nil)
-		return
-	}
-
 	// Create a new thumbnail struct with the image data and media type
 	// Add the thumbnail to the global map, using the video's ID as the key
 	// videoThumbnails[videoID] = thumbnail{
@@ -118,17 +127,23 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 
 	// Instead of encoding to base64, update the handler
 	// to save the bytes to a file at the path /assets/<videoID>.<file_extension>
-	url := cfg.getAssetURL(assetPath)
-	video.ThumbnailURL = &url
 
-	// Update the database so that the existing video record has a new thumbnail URL
-	// by using the cfg.db.UpdateVideo function.
-	err = cfg.db.UpdateVideo(video)
+	// Every variant is re-encoded as JPEG whatever was uploaded. Only the
+	// thumbnail columns are written, the video may have been processed since
+	// it was read.
+	oldURL, oldThumbnails, err := cfg.db.ReplaceVideoThumbnail(videoID, url, thumbnails, "image/jpeg")
 	if err != nil {
-		// delete(videoThumbnails, videoID)
+		cfg.deleteThumbnails(r.Context(), &url, thumbnails)
 		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
 		return
 	}
+	cfg.deleteThumbnails(r.Context(), oldURL, oldThumbnails)
+
+	video, err = cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
 
 	// Respond with updated JSON of the video's metadata.
 	// Use the provided respondwithJSON function and pass it the updated database.Video struct to marshal.
@@ -0,0 +1,601 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/progress"
+	"github.com/google/uuid"
+)
+
+// Resumable video uploads following the tus 1.0 protocol (https://tus.io/protocols/resumable-upload)
+// with the creation, termination and expiration extensions. The partial file is staged
+// in cfg.uploadDir and its offset is tracked in the video_uploads table, so
+// an upload survives dropped connections and server restarts. An upload
+// nobody writes to for cfg.tusUploadExpiry expires and is swept up.
+const (
+	tusVersion = "1.0.0"
+	// tusSweepInterval is how often expired uploads are cleaned up
+	tusSweepInterval = time.Hour
+)
+
+// errVideoGone means the video an upload was for is gone or in the trash
+var errVideoGone = errors.New("video not found")
+
+// uploadLocks keeps two requests from writing to the same staging file at
+// once. Offsets are checked again in the database, but by then the bytes of
+// the request that lost are already written.
+type uploadLocks struct {
+	mu   sync.Mutex
+	held map[uuid.UUID]bool
+}
+
+func newUploadLocks() *uploadLocks {
+	return &uploadLocks{held: map[uuid.UUID]bool{}}
+}
+
+// tryLock takes the lock on the upload, reporting false if it's already held
+func (l *uploadLocks) tryLock(id uuid.UUID) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.held[id] {
+		return false
+	}
+	l.held[id] = true
+	return true
+}
+
+func (l *uploadLocks) unlock(id uuid.UUID) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.held, id)
+}
+
+func (cfg *apiConfig) handlerTusOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", "creation,termination,expiration")
+	w.Header().Set("Tus-Max-Size", strconv.Itoa(videoUploadLimit))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerTusCreate(w http.ResponseWriter, r *http.Request) {
+	if !checkTusResumable(w, r) {
+		return
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't find video", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusUnauthorized, "Not authorized to update this video", nil)
+		return
+	}
+	if !video.Status.CanTransitionTo(database.VideoStatusUploading) {
+		respondWithError(w, http.StatusConflict, "Video can't be uploaded while it's "+string(video.Status), nil)
+		return
+	}
+
+	uploadLength, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || uploadLength < 0 {
+		respondWithError(w, http.StatusBadRequest, "Invalid Upload-Length", err)
+		return
+	}
+	if uploadLength > videoUploadLimit {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Upload exceeds the maximum size", nil)
+		return
+	}
+
+	metadata, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Upload-Metadata", err)
+		return
+	}
+	mediaType, _, err := mime.ParseMediaType(metadata["filetype"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid filetype in Upload-Metadata", err)
+		return
+	}
+	if mediaType != "video/mp4" {
+		respondWithError(w, http.StatusBadRequest, "Invalid file type, only MP4 is allowed", nil)
+		return
+	}
+
+	// A client that lost its upload URL starts over, the upload it abandoned is dropped
+	previous, err := cfg.db.GetIncompleteVideoUploads(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get uploads", err)
+		return
+	}
+	for _, upload := range previous {
+		if !cfg.tusLocks.tryLock(upload.ID) {
+			respondWithError(w, http.StatusConflict, "Another upload of this video is being written", nil)
+			return
+		}
+		cfg.removeTusUpload(upload)
+		cfg.tusLocks.unlock(upload.ID)
+	}
+
+	stagingFile, err := os.CreateTemp(cfg.uploadDir, "tus-*.mp4")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not create staging file", err)
+		return
+	}
+	stagingFile.Close()
+
+	upload, err := cfg.db.CreateVideoUpload(database.CreateVideoUploadParams{
+		VideoID:   videoID,
+		UserID:    userID,
+		Length:    uploadLength,
+		MediaType: mediaType,
+		FilePath:  stagingFile.Name(),
+	})
+	if err != nil {
+		os.Remove(stagingFile.Name())
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create upload", err)
+		return
+	}
+
+	err = cfg.db.UpdateVideoStatus(videoID, database.VideoStatusUploading, "")
+	if err != nil {
+		// Nothing can resume an upload the video never moved to
+		if err := cfg.db.DeleteVideoUpload(upload.ID); err != nil {
+			log.Printf("Couldn't delete upload %s: %v", upload.ID, err)
+		}
+		os.Remove(stagingFile.Name())
+		if errors.Is(err, database.ErrInvalidStatusTransition) {
+			respondWithError(w, http.StatusConflict, "Couldn't start upload", err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start upload", err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/video_upload/%s/tus/%s", videoID, upload.ID))
+	cfg.setUploadExpires(w, upload)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (cfg *apiConfig) handlerTusHead(w http.ResponseWriter, r *http.Request) {
+	if !checkTusResumable(w, r) {
+		return
+	}
+	upload, ok := cfg.getAuthorizedTusUpload(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	cfg.setUploadExpires(w, upload)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (cfg *apiConfig) handlerTusPatch(w http.ResponseWriter, r *http.Request) {
+	if !checkTusResumable(w, r) {
+		return
+	}
+	upload, ok := cfg.getAuthorizedTusUpload(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/offset+octet-stream", nil)
+		return
+	}
+
+	if !cfg.tusLocks.tryLock(upload.ID) {
+		respondWithError(w, http.StatusLocked, "Upload is being written by another request", nil)
+		return
+	}
+	defer cfg.tusLocks.unlock(upload.ID)
+	// Re-read now that nobody else can move the offset
+	upload, err := cfg.db.GetVideoUpload(upload.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get upload", err)
+		return
+	}
+	if upload.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Upload not found", nil)
+		return
+	}
+	if upload.CompletedAt != nil {
+		respondWithError(w, http.StatusForbidden, "Upload is already complete", nil)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		respondWithError(w, http.StatusBadRequest, "Invalid Upload-Offset", err)
+		return
+	}
+	if offset != upload.Offset {
+		respondWithError(w, http.StatusConflict, "Upload-Offset doesn't match the current offset", nil)
+		return
+	}
+
+	f, err := os.OpenFile(upload.FilePath, os.O_WRONLY, 0)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't open staging file", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't seek staging file", err)
+		return
+	}
+
+	// Keep whatever arrived even if the connection drops halfway,
+	// the client will HEAD for the offset and resume from there.
+	written, copyErr := io.Copy(f, io.LimitReader(r.Body, upload.Length-offset))
+	if written > 0 {
+		if err := f.Sync(); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't write staging file", err)
+			return
+		}
+		err = cfg.db.AdvanceVideoUpload(upload.ID, offset, offset+written)
+		if errors.Is(err, database.ErrUploadOffsetMismatch) {
+			respondWithError(w, http.StatusConflict, "Upload was modified concurrently", err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't save upload offset", err)
+			return
+		}
+		upload.Offset = offset + written
+		upload.UpdatedAt = time.Now()
+		cfg.progress.Publish(progress.Event{
+			VideoID: upload.VideoID,
+			Stage:   progress.StageUploading,
+			Percent: float64(upload.Offset) / float64(upload.Length) * 100,
+		})
+	}
+	if copyErr != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read upload chunk", copyErr)
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	cfg.setUploadExpires(w, upload)
+	if upload.Offset == upload.Length {
+		// An empty PATCH at the final offset retries if queueing failed the first time
+		err := cfg.finishTusUpload(upload)
+		if errors.Is(err, errUnsupportedMediaType) {
+			// The whole file is here and it's not what it claimed, there's nothing to resume
+			cfg.discardTusUpload(upload)
+			respondWithError(w, http.StatusUnsupportedMediaType, err.Error(), err)
+			return
+		}
+		if errors.Is(err, errVideoGone) {
+			// Kept until it expires, in case the video is restored from the trash
+			respondWithError(w, http.StatusNotFound, "Video not found", err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't queue video processing", err)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerTusDelete(w http.ResponseWriter, r *http.Request) {
+	if !checkTusResumable(w, r) {
+		return
+	}
+	upload, ok := cfg.getAuthorizedTusUpload(w, r)
+	if !ok {
+		return
+	}
+	if !cfg.tusLocks.tryLock(upload.ID) {
+		respondWithError(w, http.StatusLocked, "Upload is being written by another request", nil)
+		return
+	}
+	defer cfg.tusLocks.unlock(upload.ID)
+
+	err := cfg.db.DeleteVideoUpload(upload.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete upload", err)
+		return
+	}
+	// Once complete, the staging file belongs to the processing job
+	if upload.CompletedAt == nil {
+		if err := os.Remove(upload.FilePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Couldn't remove staging file %s: %v", upload.FilePath, err)
+		}
+		cfg.cancelVideoUpload(upload.VideoID)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// finishTusUpload hands a fully received upload to the same processing job as handlerUploadVideo
+func (cfg *apiConfig) finishTusUpload(upload database.VideoUpload) error {
+	video, err := cfg.db.GetVideo(upload.VideoID)
+	if err != nil {
+		return fmt.Errorf("couldn't find video: %w", err)
+	}
+	if video.ID == uuid.Nil {
+		return errVideoGone
+	}
+	if video.UserID != upload.UserID {
+		return errors.New("video no longer belongs to the uploader")
+	}
+
+	// Don't trust the filetype from Upload-Metadata, ask ffprobe what the file really is
+	detectedType, err := probeVideoType(upload.FilePath)
+	if err != nil {
+		return err
+	}
+	if err := checkDetectedType(upload.MediaType, detectedType); err != nil {
+		return err
+	}
+
+	// The job owns the staging file from here on and removes it when it's done
+	_, err = cfg.enqueueVideoProcessing(video, upload.FilePath, detectedType)
+	if err != nil {
+		return err
+	}
+
+	if err := cfg.db.CompleteVideoUpload(upload.ID); err != nil {
+		return fmt.Errorf("couldn't complete upload: %w", err)
+	}
+	return nil
+}
+
+// discardTusUpload throws away an upload that can't be processed
+func (cfg *apiConfig) discardTusUpload(upload database.VideoUpload) {
+	cfg.removeTusUpload(upload)
+	cfg.cancelVideoUpload(upload.VideoID)
+}
+
+// removeTusUpload deletes an unfinished upload and its staging file
+func (cfg *apiConfig) removeTusUpload(upload database.VideoUpload) {
+	if err := os.Remove(upload.FilePath); err != nil && !os.IsNotExist(err) {
+		log.Printf("Couldn't remove staging file %s: %v", upload.FilePath, err)
+	}
+	if err := cfg.db.DeleteVideoUpload(upload.ID); err != nil {
+		log.Printf("Couldn't delete upload %s: %v", upload.ID, err)
+	}
+}
+
+// tusUploadExpires is when an unfinished upload expires if nothing more is written to it
+func (cfg *apiConfig) tusUploadExpires(upload database.VideoUpload) time.Time {
+	return upload.UpdatedAt.Add(cfg.tusUploadExpiry)
+}
+
+// setUploadExpires sets the Upload-Expires header of the expiration extension
+func (cfg *apiConfig) setUploadExpires(w http.ResponseWriter, upload database.VideoUpload) {
+	if upload.CompletedAt == nil {
+		w.Header().Set("Upload-Expires", cfg.tusUploadExpires(upload).UTC().Format(http.TimeFormat))
+	}
+}
+
+// startTusUploadSweep cleans up expired uploads every tusSweepInterval, until ctx is cancelled
+func (cfg *apiConfig) startTusUploadSweep(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(tusSweepInterval)
+		defer ticker.Stop()
+		for {
+			cfg.sweepTusUploads()
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// sweepTusUploads deletes unfinished uploads that expired along with their
+// staging files, and the rows of finished ones once their video is processed
+func (cfg *apiConfig) sweepTusUploads() {
+	cutoff := time.Now().Add(-cfg.tusUploadExpiry)
+	uploads, err := cfg.db.GetVideoUploadsUpdatedBefore(cutoff)
+	if err != nil {
+		log.Printf("Couldn't get expired uploads: %v", err)
+		return
+	}
+
+	expired := 0
+	for _, upload := range uploads {
+		if upload.CompletedAt != nil {
+			// The processing job owns the staging file and the row keeps it
+			// from looking abandoned below, so wait for the job
+			video, err := cfg.db.GetVideoWithTrashed(upload.VideoID)
+			if err != nil || video.Status == database.VideoStatusProcessing {
+				continue
+			}
+			if _, err := cfg.db.DeleteVideoUploadIfIdle(upload.ID, cutoff); err != nil {
+				log.Printf("Couldn't delete upload %s: %v", upload.ID, err)
+			}
+			continue
+		}
+
+		if !cfg.tusLocks.tryLock(upload.ID) {
+			continue
+		}
+		// Checked again by the delete in case a PATCH landed since
+		deleted, err := cfg.db.DeleteVideoUploadIfIdle(upload.ID, cutoff)
+		if err != nil {
+			log.Printf("Couldn't delete upload %s: %v", upload.ID, err)
+		} else if deleted {
+			if err := os.Remove(upload.FilePath); err != nil && !os.IsNotExist(err) {
+				log.Printf("Couldn't remove staging file %s: %v", upload.FilePath, err)
+			}
+			cfg.cancelVideoUpload(upload.VideoID)
+			expired++
+		}
+		cfg.tusLocks.unlock(upload.ID)
+	}
+	if expired > 0 {
+		log.Printf("Expired %d abandoned uploads", expired)
+	}
+
+	cfg.sweepStagingFiles(cutoff)
+}
+
+// sweepStagingFiles removes tus staging files no upload refers to anymore,
+// like those of videos purged from the trash, once they're older than cutoff
+func (cfg *apiConfig) sweepStagingFiles(cutoff time.Time) {
+	paths, err := cfg.db.GetVideoUploadFilePaths()
+	if err != nil {
+		log.Printf("Couldn't get upload staging files: %v", err)
+		return
+	}
+	referenced := map[string]bool{}
+	for _, p := range paths {
+		referenced[filepath.Clean(p)] = true
+	}
+
+	files, err := filepath.Glob(filepath.Join(cfg.uploadDir, "tus-*.mp4"))
+	if err != nil {
+		log.Printf("Couldn't list upload staging files: %v", err)
+		return
+	}
+	for _, file := range files {
+		if referenced[filepath.Clean(file)] {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			log.Printf("Couldn't remove staging file %s: %v", file, err)
+		}
+	}
+}
+
+// cancelVideoUpload puts a video whose upload was terminated back to
+// draft, or to ready if it still has a previously processed file.
+func (cfg *apiConfig) cancelVideoUpload(videoID uuid.UUID) {
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil || video.Status != database.VideoStatusUploading {
+		return
+	}
+	status := database.VideoStatusDraft
+	if video.VideoURL != nil {
+		status = database.VideoStatusReady
+	}
+	if err := cfg.db.UpdateVideoStatus(videoID, status, ""); err != nil {
+		log.Printf("Couldn't reset status of video %s: %v", videoID, err)
+	}
+}
+
+// getAuthorizedTusUpload loads the upload named in the URL and makes sure it belongs
+// to both the video in the URL and the authenticated user. It writes the error response itself.
+func (cfg *apiConfig) getAuthorizedTusUpload(w http.ResponseWriter, r *http.Request) (database.VideoUpload, bool) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return database.VideoUpload{}, false
+	}
+	uploadID, err := uuid.Parse(r.PathValue("uploadID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid upload ID", err)
+		return database.VideoUpload{}, false
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return database.VideoUpload{}, false
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return database.VideoUpload{}, false
+	}
+
+	upload, err := cfg.db.GetVideoUpload(uploadID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get upload", err)
+		return database.VideoUpload{}, false
+	}
+	if upload.ID == uuid.Nil || upload.VideoID != videoID {
+		respondWithError(w, http.StatusNotFound, "Upload not found", nil)
+		return database.VideoUpload{}, false
+	}
+	if upload.UserID != userID {
+		respondWithError(w, http.StatusUnauthorized, "Not authorized to access this upload", nil)
+		return database.VideoUpload{}, false
+	}
+	if upload.CompletedAt == nil && time.Now().After(cfg.tusUploadExpires(upload)) {
+		respondWithError(w, http.StatusGone, "Upload expired", nil)
+		return database.VideoUpload{}, false
+	}
+	return upload, true
+}
+
+// checkTusResumable sets the Tus-Resumable response header and rejects
+// clients speaking a protocol version we don't support.
+func checkTusResumable(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		respondWithError(w, http.StatusPreconditionFailed, "Unsupported Tus-Resumable version", nil)
+		return false
+	}
+	return true
+}
+
+// parseTusMetadata decodes an Upload-Metadata header: comma separated
+// pairs of a key and an optional base64 encoded value.
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		parts := strings.Fields(pair)
+		switch len(parts) {
+		case 1:
+			metadata[parts[0]] = ""
+		case 2:
+			value, err := base64.StdEncoding.DecodeString(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid value for key %s: %w", parts[0], err)
+			}
+			metadata[parts[0]] = string(value)
+		default:
+			return nil, fmt.Errorf("invalid metadata pair %q", pair)
+		}
+	}
+	return metadata, nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestParseTusMetadata(t *testing.T) {
+	metadata, err := parseTusMetadata("filename Ym9vdHMubXA0,filetype dmlkZW8vbXA0, is_draft")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"filename": "boots.mp4", "filetype": "video/mp4", "is_draft": ""}
+	if len(metadata) != len(want) {
+		t.Fatalf("metadata = %v, want %v", metadata, want)
+	}
+	for k, v := range want {
+		if metadata[k] != v {
+			t.Errorf("metadata[%s] = %q, want %q", k, metadata[k], v)
+		}
+	}
+
+	if metadata, err := parseTusMetadata(" "); err != nil || len(metadata) != 0 {
+		t.Errorf("empty header = %v, %v", metadata, err)
+	}
+	for _, bad := range []string{"filename not-base64!", "filename Zm9v extra"} {
+		if _, err := parseTusMetadata(bad); err == nil {
+			t.Errorf("%q was accepted", bad)
+		}
+	}
+}
+
+func TestUploadLocks(t *testing.T) {
+	locks := newUploadLocks()
+	a, b := uuid.New(), uuid.New()
+	if !locks.tryLock(a) {
+		t.Fatal("couldn't lock a free upload")
+	}
+	if locks.tryLock(a) {
+		t.Fatal("locked an upload twice")
+	}
+	if !locks.tryLock(b) {
+		t.Fatal("one upload's lock blocked another")
+	}
+	locks.unlock(a)
+	if !locks.tryLock(a) {
+		t.Fatal("couldn't lock an upload after unlocking it")
+	}
+}
+
+func TestSweepTusUploads(t *testing.T) {
+	cfg := &apiConfig{
+		db:              newTestDB(t),
+		uploadDir:       t.TempDir(),
+		tusUploadExpiry: time.Nanosecond,
+		tusLocks:        newUploadLocks(),
+	}
+	startUpload := func(status database.VideoStatus) (database.Video, database.VideoUpload) {
+		t.Helper()
+		video := createTestVideo(t, cfg.db)
+		if err := cfg.db.UpdateVideoStatus(video.ID, status, ""); err != nil {
+			t.Fatal(err)
+		}
+		file := filepath.Join(cfg.uploadDir, "tus-"+uuid.NewString()+".mp4")
+		if err := os.WriteFile(file, []byte("partial"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		upload, err := cfg.db.CreateVideoUpload(database.CreateVideoUploadParams{
+			VideoID:   video.ID,
+			UserID:    video.UserID,
+			Length:    100,
+			MediaType: "video/mp4",
+			FilePath:  file,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return video, upload
+	}
+
+	abandoned, abandonedUpload := startUpload(database.VideoStatusUploading)
+	_, lockedUpload := startUpload(database.VideoStatusUploading)
+	_, processingUpload := startUpload(database.VideoStatusProcessing)
+	if err := cfg.db.CompleteVideoUpload(processingUpload.ID); err != nil {
+		t.Fatal(err)
+	}
+	stray := filepath.Join(cfg.uploadDir, "tus-stray.mp4")
+	if err := os.WriteFile(stray, []byte("left behind"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	other := filepath.Join(cfg.uploadDir, "upload-other.mp4")
+	if err := os.WriteFile(other, []byte("not ours"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.tusLocks.tryLock(lockedUpload.ID)
+	// Everything above has to be older than the cutoff, which has millisecond precision
+	time.Sleep(10 * time.Millisecond)
+	cfg.sweepTusUploads()
+
+	if upload, err := cfg.db.GetVideoUpload(abandonedUpload.ID); err != nil || upload.ID != uuid.Nil {
+		t.Errorf("abandoned upload still exists: %+v, %v", upload, err)
+	}
+	if _, err := os.Stat(abandonedUpload.FilePath); !os.IsNotExist(err) {
+		t.Errorf("abandoned upload's staging file wasn't removed: %v", err)
+	}
+	video, err := cfg.db.GetVideo(abandoned.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if video.Status != database.VideoStatusDraft {
+		t.Errorf("video of the abandoned upload has status %s, want draft", video.Status)
+	}
+
+	for name, upload := range map[string]database.VideoUpload{
+		"locked":     lockedUpload,
+		"processing": processingUpload,
+	} {
+		if got, err := cfg.db.GetVideoUpload(upload.ID); err != nil || got.ID != upload.ID {
+			t.Errorf("%s upload was swept: %+v, %v", name, got, err)
+		}
+		if _, err := os.Stat(upload.FilePath); err != nil {
+			t.Errorf("%s upload's staging file was removed: %v", name, err)
+		}
+	}
+
+	if _, err := os.Stat(stray); !os.IsNotExist(err) {
+		t.Errorf("stray staging file wasn't removed: %v", err)
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("a file that isn't a tus staging file was removed: %v", err)
+	}
+}
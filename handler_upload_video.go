@@ -1,29 +1,32 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"mime"
 	"net/http"
 	"os"
-	"os/exec"
-	"path/filepath"
+	"path"
+	"strings"
+	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/progress"
 	"github.com/google/uuid"
 )
 
+// videoUploadLimit caps a single video upload, multipart or tus, at 1 GB
+const videoUploadLimit = 1 << 30
+
 // Update the handlerUploadVideo handler code to store bucket and key as a comma delimited string in the video_url.
 func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request) {
 	// Set an upload limit of 1 GB (1 << 30 bytes)
-	const uploadLimit = 1 << 30
 	// using http.MaxBytesReader
-	r.Body = http.MaxBytesReader(w, r.Body, uploadLimit)
+	r.Body = http.MaxBytesReader(w, r.Body, videoUploadLimit)
 
 	// Extract the videoID from the URL path parameters
 	videoIDString := r.PathValue("videoID")
@@ -58,6 +61,10 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 		respondWithError(w, http.StatusUnauthorized, "Not authorized to update this video", nil)
 		return
 	}
+	if !video.Status.CanTransitionTo(database.VideoStatusProcessing) {
+		respondWithError(w, http.StatusConflict, "Video can't be uploaded while it's "+string(video.Status), nil)
+		return
+	}
 
 	// Parse the uploaded video file from the form data
 	// Use (http.Request).FormFile with the key "video" to get a multipart.File in memory
@@ -81,17 +88,22 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Save the uploaded file to a temporary file on disk.
-	// Use os.CreateTemp to create a temporary file.
-	tempFile, err := os.CreateTemp("", "tubely-upload.mp4")
+	// Save the uploaded file to a staging file on disk.
+	// It has to outlive the request, the processing job picks it up from there.
+	tempFile, err := os.CreateTemp(cfg.uploadDir, "upload-*.mp4")
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Could not create temp file", err)
 		return
 	}
-	// defer remove the temp file with os.Remove
-	defer os.Remove(tempFile.Name())
-	// defer close the temp file (defer is LIFO, so it will close before the remove)
+	// defer close the temp file
 	defer tempFile.Close()
+	// and remove it unless the job took ownership of it
+	queued := false
+	defer func() {
+		if !queued {
+			os.Remove(tempFile.Name())
+		}
+	}()
 
 	// io.Copy the contents over from the wire to the temp file
 	if _, err := io.Copy(tempFile, file); err != nil {
@@ -99,146 +111,140 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Reset the tempFile's file pointer to the beginning with .Seek(0, io.SeekStart)
-	// - this will allow us to read the file again from the beginning
-	_, err = tempFile.Seek(0, io.SeekStart)
+	// Don't trust the declared Content-Type, ask ffprobe what the file really is
+	detectedType, err := probeVideoType(tempFile.Name())
+	if err == nil {
+		err = checkDetectedType(mediaType, detectedType)
+	}
+	if errors.Is(err, errUnsupportedMediaType) {
+		respondWithError(w, http.StatusUnsupportedMediaType, err.Error(), err)
+		return
+	}
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Could not reset file pointer", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't inspect video", err)
 		return
 	}
 
-	// to get the aspect ratio of the video file from the temporary file once it's saved to disk.
-	directory := ""
-	aspectRatio, err := getVideoAspectRatio(tempFile.Name())
+	// ffprobe, ffmpeg and the upload to storage can take minutes,
+	// so hand them to a background worker and return right away.
+	job, err := cfg.enqueueVideoProcessing(video, tempFile.Name(), detectedType)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error determining aspect ratio", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't queue video processing", err)
 		return
 	}
-	switch aspectRatio {
-	case "16:9":
-		directory = "landscape"
-	case "9:16":
-		directory = "portrait"
-	default:
-		directory = "other"
+	queued = true
+
+	respondWithJSON(w, http.StatusAccepted, job)
+}
+
+// processVideoUpload runs an uploaded file through the ffprobe/faststart pipeline,
+// stores the result in the video store and records its URL on the video.
+// It runs in a background job queued by both the multipart handler and finished tus uploads,
+// reporting each stage to tracker. mediaType is the sniffed type, not the client's claim.
+func (cfg *apiConfig) processVideoUpload(ctx context.Context, video database.Video, filePath, mediaType string, tracker *progress.Tracker) (database.Video, error) {
+	tracker.Stage(progress.StageProbing)
+	probe, err := probeVideo(filePath)
+	if err != nil {
+		return video, fmt.Errorf("couldn't probe video: %w", err)
 	}
+	duration := probe.duration()
+
+	// to get the aspect ratio of the video file from the temporary file once it's saved to disk.
+	stream, ok := probe.videoStream()
+	if !ok {
+		return video, fmt.Errorf("%w: no video streams found", jobs.ErrPermanent)
+	}
+	aspectRatio := classifyAspectRatio(stream.displayDimensions())
+	directory := aspectRatioDirectory(aspectRatio)
 
 	key := getAssetPath(mediaType)
-	key = filepath.Join(directory, key)
+	key = path.Join(directory, key)
 
-	processedFilePath, err := processVideoForFastStart(tempFile.Name())
+	tracker.Stage(progress.StageFastStart)
+	processedFilePath, err := processVideoForFastStart(ctx, filePath, duration, tracker.Progress)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error processing video", err)
-		return
+		return video, err
 	}
 	defer os.Remove(processedFilePath)
 
 	processedFile, err := os.Open(processedFilePath)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Could not open processed file", err)
-		return
+		return video, fmt.Errorf("couldn't open processed file: %w", err)
 	}
 	defer processedFile.Close()
+	processedInfo, err := processedFile.Stat()
+	if err != nil {
+		return video, fmt.Errorf("couldn't stat processed file: %w", err)
+	}
 
-	// Put the object into S3 using PutObject.
-	_, err = cfg.s3Client.PutObject(r.Context(), &s3.PutObjectInput{
-		// The bucket name
-		Bucket:      aws.String(cfg.s3Bucket),
-		Key:         aws.String(key),
-		Body:        tempFile,
-		ContentType: aws.String(mediaType),
-	})
+	// Put the processed file into the video store (S3 in production).
+	tracker.Stage(progress.StageStoring)
+	err = cfg.videoStore.Put(ctx, key, tracker.Reader(processedFile, processedInfo.Size()), mediaType)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error uploading file to S3", err)
-		return
+		return video, fmt.Errorf("couldn't upload video to storage: %w", err)
+	}
+
+	// Optionally transcode adaptive bitrate HLS and DASH renditions next to the MP4
+	keyPrefix := strings.TrimSuffix(key, path.Ext(key))
+	if cfg.hlsEnabled {
+		tracker.Stage(progress.StageHLS)
+		hlsURL, err := cfg.packageHLS(ctx, processedFilePath, keyPrefix, duration, tracker.Progress)
+		if err != nil {
+			return video, err
+		}
+		video.HLSURL = &hlsURL
+	}
+	if cfg.dashEnabled {
+		tracker.Stage(progress.StageDASH)
+		dashURL, err := cfg.packageDASH(ctx, processedFilePath, keyPrefix, duration, tracker.Progress)
+		if err != nil {
+			return video, err
+		}
+		video.DASHURL = &dashURL
 	}
 
 	// Update the VideoURL of the video record in the database with the S3 bucket and key.
 	// to store bucket and key as a comma delimited string in the video_url
 	// In handlerUploadVideo don't store the bucket and key as comma separated values in the video_url field.
 	// Use your distribution's domain name, and then dynamically inject the S3 object's key.
-	url := fmt.Sprintf("%s/%s", cfg.s3CfDistribution, key)
+	url := cfg.videoStore.URL(key)
 	video.VideoURL = &url
+	video.VideoMediaType = &mediaType
 	// Store an actual URL again in the video_url column, but this time, use the cloudfront URL.
-	err = cfg.db.UpdateVideo(video)
+	// video is the row as the job found it, so only the file columns are written
+	err = cfg.db.SetVideoFiles(video.ID, url, mediaType, video.HLSURL, video.DASHURL)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
-		return
-	}
-	// Remove the dbVideoToSignedVideo method and all references to it
-	// video, err = cfg.dbVideoToSignedVideo(video)
-	// if err != nil {
-	// 	respondWithError(w, http.StatusInternalServerError, "Couldn't generate presigned URL", err)
-	// 	return
-	// }
-
-	respondWithJSON(w, http.StatusOK, video)
-}
-
-// Create a function that takes a file path and returns the aspect ratio as a string
-func getVideoAspectRatio(filePath string) (string, error) {
-	// It should use exec.Command to run the same ffprobe
-	cmd := exec.Command("ffprobe",
-		"-v", "error",
-		"-print_format", "json",
-		"-show_streams",
-		filePath,
-	)
-
-	// Set the resulting exec.Cmd's Stdout field to a pointer to a new bytes.Buffer
-	var stdout bytes.Buffer
-	cmd.Stdout = &stdout
-
-	// .Run() the command
-	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("ffprobe error: %v", err)
-	}
-
-	// Unmarshal the stdout of the command from the buffer's .Bytes
-	// into a JSON struct so that you can get
-	var output struct {
-		Streams []struct {
-			// the width
-			Width int `json:"width"`
-			// and height fields.
-			Height int `json:"height"`
-		} `json:"streams"`
-	}
-	if err := json.Unmarshal(stdout.Bytes(), &output); err != nil {
-		return "", fmt.Errorf("could not parse ffprobe output: %v", err)
+		return video, fmt.Errorf("couldn't update video: %w", err)
 	}
 
-	if len(output.Streams) == 0 {
-		return "", errors.New("no video streams found")
+	// Faststart only moves the moov atom, so the original's probe describes the
+	// stored file too, except for its size
+	meta := probe.metadata()
+	storedSize := processedInfo.Size()
+	meta.FileSize = &storedSize
+	meta.AspectRatio = &aspectRatio
+	if err := cfg.db.UpdateVideoMetadata(video.ID, meta); err != nil {
+		return video, fmt.Errorf("couldn't save video metadata: %w", err)
 	}
+	video.MediaMetadata = meta
 
-	width := output.Streams[0].Width
-	height := output.Streams[0].Height
-
-	if width == 16*height/9 {
-		return "16:9", nil
-	} else if height == 16*width/9 {
-		return "9:16", nil
-	}
-	return "other", nil
+	tracker.Stage(progress.StageThumbnail)
+	cfg.autoThumbnail(ctx, video, processedFilePath, duration)
+	return video, nil
 }
 
 // Create a new function that takes a file path as input
 // and creates and returns a new path to a file with "fast start" encoding
-func processVideoForFastStart(filePath string) (string, error) {
+func processVideoForFastStart(ctx context.Context, filePath string, duration time.Duration, onProgress func(float64)) (string, error) {
 	// Create a new string for the output file path
 	// appended .processing to the input file
 	newFilePath := fmt.Sprintf("%s.processing", filePath)
-	// Create a new exec.Cmd using exec.Command
-	exec := exec.Command("ffmpeg", "-i", filePath, "-movflags", "faststart",
-		"-codec", "copy", "-f", "mp4", newFilePath)
 
-	var stderr bytes.Buffer
-	exec.Stderr = &stderr
-
-	// Run the command
-	if err := exec.Run(); err != nil {
-		return "", fmt.Errorf("error processing video: %s, %v", stderr.String(), err)
+	// Run the command, reporting how far along it is
+	err := runFFmpeg(ctx, duration, onProgress, "-y", "-i", filePath, "-movflags", "faststart",
+		"-codec", "copy", "-f", "mp4", newFilePath)
+	if err != nil {
+		return "", fmt.Errorf("error processing video: %v", err)
 	}
 
 	fileInfo, err := os.Stat(newFilePath)
@@ -252,42 +258,3 @@ func processVideoForFastStart(filePath string) (string, error) {
 	// Return the output file path
 	return newFilePath, nil
 }
-
-// Remove the dbVideoToSignedVideo method and all references to it
-// func (cfg *apiConfig) dbVideoToSignedVideo(video database.Video) (database.Video, error) {
-// 	if video.VideoURL == nil {
-// 		return video, nil
-// 	}
-// 	// It should first split the video.VideoURL on the comma
-// 	parts := strings.Split(*video.VideoURL, ",")
-// 	if len(parts) < 2 {
-// 		return video, nil
-// 	}
-// 	// to get the bucket
-// 	bucket := parts[0]
-// 	// and key
-// 	key := parts[1]
-// 	// use generatePresignedURL to get a presigned URL for the video
-// 	// Remove generatePresignedURL function and all references to it
-
-// 	// with the VideoURL field set to a presigned URL
-// 	video.VideoURL = &presigned
-// 	return video, nil
-// }
-
-// Remove generatePresignedURL function and all references to it
-
-// func generatePresignedURL(s3Client *s3.Client, bucket, key string, expireTime time.Duration) (string, error) {
-// 	// Use the SDK to create a s3.PresignClient with s3.NewPresignClient
-// 	presignClient := s3.NewPresignClient(s3Client)
-// 	// Use the client's .PresignGetObject() method with s3.WithPresignExpires as a functional option.
-// 	presignedUrl, err := presignClient.PresignGetObject(context.TODO(), &s3.GetObjectInput{
-// 		Bucket: aws.String(bucket),
-// 		Key:    aws.String(key),
-// 	}, s3.WithPresignExpires(expireTime))
-// 	if err != nil {
-// 		return "", fmt.Errorf("failed to generate presigned URL: %v", err)
-// 	}
-// 	// Return the .URL field of the v4.PresignedHTTPRequest created by .PresignGetObject()
-// 	return presignedUrl.URL, nil
-// }
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/progress"
+	"github.com/google/uuid"
+)
+
+const (
+	sseKeepaliveInterval = 15 * time.Second
+	// eventsURLTTL is how long the signed events URL from the status endpoint
+	// can be used to connect. A stream that's already open isn't cut off.
+	eventsURLTTL = 5 * time.Minute
+)
+
+// handlerVideoEvents streams processing progress as server-sent events.
+// EventSource can't set headers, so instead of the JWT it can use the
+// short-lived signed URL handed to the owner by the status endpoint.
+func (cfg *apiConfig) handlerVideoEvents(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	var userID uuid.UUID
+	signed := r.Header.Get("Authorization") == "" && r.URL.Query().Has("sig")
+	if signed {
+		if err := auth.ValidatePathSignature(r.URL.Path, r.URL.Query(), cfg.jwtSecret); err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't validate URL signature", err)
+			return
+		}
+	} else {
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+			return
+		}
+		userID, err = auth.ValidateJWT(token, cfg.jwtSecret)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+			return
+		}
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	// Signed URLs are only handed to the owner
+	if video.ID == uuid.Nil || (!signed && video.UserID != userID) {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Streaming unsupported", nil)
+		return
+	}
+
+	// Subscribe before reading the status so nothing published in between is missed
+	events, latest, unsubscribe := cfg.progress.Subscribe(videoID)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	first := latest
+	if first == nil {
+		event := statusEvent(video)
+		first = &event
+	}
+	if err := writeProgressEvent(w, *first); err != nil {
+		return
+	}
+	flusher.Flush()
+	if first.Done {
+		return
+	}
+
+	keepalive := time.NewTicker(sseKeepaliveInterval)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case event := <-events:
+			if err := writeProgressEvent(w, event); err != nil {
+				return
+			}
+			flusher.Flush()
+			if event.Done {
+				return
+			}
+		}
+	}
+}
+
+// statusEvent describes a video nothing is currently reporting progress for
+func statusEvent(video database.Video) progress.Event {
+	event := progress.Event{VideoID: video.ID, Stage: string(video.Status)}
+	switch video.Status {
+	case database.VideoStatusReady:
+		event.Percent = 100
+		event.Done = true
+	case database.VideoStatusFailed:
+		event.Done = true
+		if video.StatusError != nil {
+			event.Error = *video.StatusError
+		}
+	case database.VideoStatusProcessing:
+		// Queued, or processed by a worker on another server
+		event.Stage = progress.StageQueued
+	}
+	return event
+}
+
+func writeProgressEvent(w http.ResponseWriter, event progress.Event) error {
+	dat, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: progress\ndata: %s\n\n", dat)
+	return err
+}
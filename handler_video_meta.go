@@ -2,7 +2,14 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
@@ -33,6 +40,13 @@ func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Requ
 		return
 	}
 	params.UserID = userID
+	if params.Visibility == "" {
+		params.Visibility = database.VideoVisibilityPrivate
+	}
+	if err := validateVideoDetails(&params.Title, &params.Description, &params.Visibility); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
 
 	video, err := cfg.db.CreateVideo(params.CreateVideoParams)
 	if err != nil {
@@ -43,6 +57,8 @@ func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Requ
 	respondWithJSON(w, http.StatusCreated, video)
 }
 
+// handlerVideoMetaDelete moves a video to the trash. It can be restored until
+// it's purged, along with its stored files, after the trash retention period.
 func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Request) {
 	videoIDString := r.PathValue("videoID")
 	videoID, err := uuid.Parse(videoIDString)
@@ -67,12 +83,16 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
 		return
 	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
 	if video.UserID != userID {
 		respondWithError(w, http.StatusForbidden, "You can't delete this video", err)
 		return
 	}
 
-	err = cfg.db.DeleteVideo(videoID)
+	err = cfg.db.TrashVideo(videoID)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
 		return
@@ -81,7 +101,21 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
+const (
+	maxVideoTitleLength       = 200
+	maxVideoDescriptionLength = 5000
+)
+
+// handlerVideoMetaUpdate edits a video's title, description and visibility.
+// Fields left out of the body are unchanged. Sending the ETag from a previous response in
+// If-Match makes the edit fail with 412 if someone else changed the video since.
+func (cfg *apiConfig) handlerVideoMetaUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Title       *string                   `json:"title"`
+		Description *string                   `json:"description"`
+		Visibility  *database.VideoVisibility `json:"visibility"`
+	}
+
 	videoIDString := r.PathValue("videoID")
 	videoID, err := uuid.Parse(videoIDString)
 	if err != nil {
@@ -89,22 +123,169 @@ func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if err := validateVideoDetails(params.Title, params.Description, params.Visibility); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
 	video, err := cfg.db.GetVideo(videoID)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't edit this video", nil)
 		return
 	}
 
-	// Remove the dbVideoToSignedVideo method and all references to it
-	// video, err = cfg.dbVideoToSignedVideo(video)
-	// if err != nil {
-	// 	respondWithError(w, http.StatusInternalServerError, "Couldn't generate presigned URL", err)
-	// 	return
-	// }
+	var ifUpdatedAt *time.Time
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		if !etagMatches(withoutSigningWindow(ifMatch), videoETag(video)) {
+			respondWithError(w, http.StatusPreconditionFailed, "Video was changed since you last fetched it", nil)
+			return
+		}
+		// Checked again by the update in case it changes in the meantime
+		ifUpdatedAt = &video.UpdatedAt
+	}
 
+	video, err = cfg.db.UpdateVideoDetails(videoID, database.UpdateVideoDetailsParams{
+		Title:       params.Title,
+		Description: params.Description,
+		Visibility:  params.Visibility,
+	}, ifUpdatedAt)
+	if errors.Is(err, database.ErrVideoModified) {
+		respondWithError(w, http.StatusPreconditionFailed, "Video was changed since you last fetched it", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+
+	w.Header().Set("ETag", videoETag(video))
 	respondWithJSON(w, http.StatusOK, video)
 }
 
+// validateVideoDetails checks the fields of a video update that were set
+func validateVideoDetails(title, description *string, visibility *database.VideoVisibility) error {
+	if title == nil && description == nil && visibility == nil {
+		return errors.New("nothing to update, set title, description or visibility")
+	}
+	if title != nil {
+		*title = strings.TrimSpace(*title)
+		if *title == "" {
+			return errors.New("title can't be empty")
+		}
+		if utf8.RuneCountInString(*title) > maxVideoTitleLength {
+			return fmt.Errorf("title can't be longer than %d characters", maxVideoTitleLength)
+		}
+	}
+	if description != nil && utf8.RuneCountInString(*description) > maxVideoDescriptionLength {
+		return fmt.Errorf("description can't be longer than %d characters", maxVideoDescriptionLength)
+	}
+	if visibility != nil && !visibility.Valid() {
+		return errors.New("visibility must be private, unlisted or public")
+	}
+	return nil
+}
+
+// viewerID returns the signed in user, or uuid.Nil for requests without a JWT.
+// Only a JWT that's there but invalid is an error.
+func (cfg *apiConfig) viewerID(r *http.Request) (uuid.UUID, error) {
+	token, err := auth.GetBearerToken(r.Header)
+	if errors.Is(err, auth.ErrNoAuthHeaderIncluded) {
+		return uuid.Nil, nil
+	}
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return auth.ValidateJWT(token, cfg.jwtSecret)
+}
+
+// handlerVideoGet returns a video to anyone allowed to watch it. Signing in is
+// optional, but private videos are only found by their owner.
+func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	viewerID, err := cfg.viewerID(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetVisibleVideo(videoID, viewerID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	// A private video looks just like a missing one to everyone else
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+
+	// Let caches keep the video but check back every time. Shared caches
+	// mustn't hand a private video to someone else.
+	if video.Visibility == database.VideoVisibilityPrivate {
+		// Private videos always come with a signed stream_url, so the ETag
+		// says when the URLs were signed and If-Modified-Since, which can't, is ignored
+		etag := signedVideoETag(video, cfg.signedURLTTL, time.Now())
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", "private, no-cache")
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	} else {
+		etag := videoETag(video)
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", video.UpdatedAt.UTC().Format(http.TimeFormat))
+		w.Header().Set("Cache-Control", "no-cache")
+		if notModified(r, etag, video.UpdatedAt) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	video, err = cfg.signVideoURLs(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URL", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, struct {
+		database.Video
+		StreamURL *string `json:"stream_url,omitempty"`
+	}{video, cfg.streamURL(video)})
+}
+
 func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Request) {
 	token, err := auth.GetBearerToken(r.Header)
 	if err != nil {
@@ -117,21 +298,94 @@ func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	videos, err := cfg.db.GetVideos(userID)
+	params, err := parseListVideosParams(r.URL.Query())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	params.UserID = userID
+
+	page, err := cfg.db.ListVideos(params)
+	if errors.Is(err, database.ErrInvalidCursor) {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
 		return
 	}
 
-	// Remove the dbVideoToSignedVideo method and all references to it
-	// for i, video := range videos {
-	// 	video, err = cfg.dbVideoToSignedVideo(video)
-	// 	if err != nil {
-	// 		respondWithError(w, http.StatusInternalServerError, "Couldn't generate presigned URL", err)
-	// 		return
-	// 	}
-	// 	videos[i] = video
-	// }
+	for i, video := range page.Videos {
+		page.Videos[i], err = cfg.signVideoURLs(r.Context(), video)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URL", err)
+			return
+		}
+	}
 
-	respondWithJSON(w, http.StatusOK, videos)
+	respondWithJSON(w, http.StatusOK, page)
+}
+
+// parseListVideosParams reads the paging, sorting and filtering query parameters of GET /api/videos:
+// limit, cursor, sort (created_at, updated_at, title, duration), order (asc, desc),
+// has_video, aspect_ratio, created_after and created_before (RFC 3339 or YYYY-MM-DD)
+func parseListVideosParams(query url.Values) (database.ListVideosParams, error) {
+	params := database.ListVideosParams{
+		Sort:   database.VideoSortCreatedAt,
+		Cursor: query.Get("cursor"),
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > database.MaxVideoListLimit {
+			return params, fmt.Errorf("limit must be between 1 and %d", database.MaxVideoListLimit)
+		}
+		params.Limit = n
+	}
+
+	if sort := query.Get("sort"); sort != "" {
+		params.Sort = database.VideoSort(sort)
+		if !params.Sort.Valid() {
+			return params, fmt.Errorf("can't sort by %q", sort)
+		}
+	}
+	// Newest, most recently updated and longest first, titles alphabetically
+	params.Descending = params.Sort != database.VideoSortTitle
+	switch query.Get("order") {
+	case "":
+	case "asc":
+		params.Descending = false
+	case "desc":
+		params.Descending = true
+	default:
+		return params, errors.New("order must be asc or desc")
+	}
+
+	if hasVideo := query.Get("has_video"); hasVideo != "" {
+		b, err := strconv.ParseBool(hasVideo)
+		if err != nil {
+			return params, errors.New("has_video must be true or false")
+		}
+		params.HasVideo = &b
+	}
+	params.AspectRatio = query.Get("aspect_ratio")
+
+	for name, dest := range map[string]**time.Time{
+		"created_after":  &params.CreatedAfter,
+		"created_before": &params.CreatedBefore,
+	} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			t, err = time.Parse(time.DateOnly, value)
+		}
+		if err != nil {
+			return params, fmt.Errorf("%s must be an RFC 3339 time or a YYYY-MM-DD date", name)
+		}
+		*dest = &t
+	}
+	return params, nil
 }
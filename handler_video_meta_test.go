@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestParseListVideosParams(t *testing.T) {
+	params, err := parseListVideosParams(url.Values{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.Sort != database.VideoSortCreatedAt || !params.Descending || params.Limit != 0 {
+		t.Errorf("defaults = %+v, want newest first with the default limit", params)
+	}
+
+	query, _ := url.ParseQuery("sort=title&limit=5&cursor=abc&has_video=false&aspect_ratio=9:16&created_after=2024-03-01&created_before=2024-03-02T12:00:00Z")
+	params, err = parseListVideosParams(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.Sort != database.VideoSortTitle || params.Descending {
+		t.Errorf("sort=title gave %s descending=%v, want titles A to Z", params.Sort, params.Descending)
+	}
+	if params.Limit != 5 || params.Cursor != "abc" || params.AspectRatio != "9:16" {
+		t.Errorf("params = %+v", params)
+	}
+	if params.HasVideo == nil || *params.HasVideo {
+		t.Errorf("has_video = %v, want false", params.HasVideo)
+	}
+	if params.CreatedAfter == nil || !params.CreatedAfter.Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("created_after = %v", params.CreatedAfter)
+	}
+	if params.CreatedBefore == nil || !params.CreatedBefore.Equal(time.Date(2024, 3, 2, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("created_before = %v", params.CreatedBefore)
+	}
+
+	params, err = parseListVideosParams(url.Values{"sort": {"duration"}, "order": {"asc"}})
+	if err != nil || params.Descending {
+		t.Errorf("order=asc gave descending=%v, %v", params.Descending, err)
+	}
+
+	for _, bad := range []string{
+		"limit=0",
+		"limit=101",
+		"limit=ten",
+		"sort=views",
+		"order=up",
+		"has_video=maybe",
+		"created_after=last+week",
+	} {
+		query, _ := url.ParseQuery(bad)
+		if _, err := parseListVideosParams(query); err == nil {
+			t.Errorf("%s was accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerVideoStatusGet(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		ID          uuid.UUID            `json:"id"`
+		Status      database.VideoStatus `json:"status"`
+		StatusError *string              `json:"status_error"`
+		UpdatedAt   time.Time            `json:"updated_at"`
+		// EventsURL is the progress stream, signed so EventSource can open it without the JWT
+		EventsURL string `json:"events_url"`
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || video.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return
+	}
+
+	// Pollers must always see the latest state
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, http.StatusOK, response{
+		ID:          video.ID,
+		Status:      video.Status,
+		StatusError: video.StatusError,
+		UpdatedAt:   video.UpdatedAt,
+		EventsURL:   cfg.signedPath(fmt.Sprintf("/api/videos/%s/events", video.ID), eventsURLTTL),
+	})
+}
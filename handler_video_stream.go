@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// handlerVideoStream plays a video's MP4 through the API, for deployments
+// without a CDN in front of the storage. It supports Range and If-Range so
+// players can seek, and only reads the requested bytes from the store.
+// A <video> element can't send an Authorization header, so private videos
+// play from the short-lived signed stream_url handed out by handlerVideoGet.
+func (cfg *apiConfig) handlerVideoStream(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	var video database.Video
+	if r.Header.Get("Authorization") == "" && r.URL.Query().Has("sig") {
+		// The signature covers the path, so it's only good for this video
+		if err := auth.ValidatePathSignature(r.URL.Path, r.URL.Query(), cfg.jwtSecret); err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't validate URL signature", err)
+			return
+		}
+		video, err = cfg.db.GetVideo(videoID)
+	} else {
+		var viewerID uuid.UUID
+		viewerID, err = cfg.viewerID(r)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+			return
+		}
+		video, err = cfg.db.GetVisibleVideo(videoID, viewerID)
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	if video.VideoURL == nil {
+		respondWithError(w, http.StatusNotFound, "Video has no file yet", nil)
+		return
+	}
+	key, ok := storage.KeyFromURL(cfg.videoStore, *video.VideoURL)
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Video file isn't in storage", nil)
+		return
+	}
+
+	reader, err := storage.NewObjectReader(r.Context(), cfg.videoStore, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		respondWithError(w, http.StatusNotFound, "Video file not found", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read video file", err)
+		return
+	}
+	defer reader.Close()
+	info := reader.Info()
+
+	contentType := info.ContentType
+	if video.VideoMediaType != nil {
+		contentType = *video.VideoMediaType
+	}
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	// The object's version, so If-Range only resumes a download of the same file
+	w.Header().Set("ETag", `"`+strconv.FormatInt(info.LastModified.UnixNano(), 36)+"-"+strconv.FormatInt(info.Size, 36)+`"`)
+	if video.Visibility == database.VideoVisibilityPrivate {
+		w.Header().Set("Cache-Control", "private, no-cache")
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+
+	// ServeContent answers Range, If-Range and the other conditional headers,
+	// with 206 and the right Content-Length and Content-Range
+	http.ServeContent(w, r, "", info.LastModified, reader)
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// newStreamTestConfig returns a config with one private video whose file is "0123456789abcdef"
+func newStreamTestConfig(t *testing.T) (*apiConfig, database.Video) {
+	t.Helper()
+	cfg := &apiConfig{
+		db:           newTestDB(t),
+		jwtSecret:    "secret",
+		videoStore:   storage.NewMemoryStore("http://localhost:8091/assets"),
+		signedURLTTL: time.Hour,
+	}
+	video := createTestVideo(t, cfg.db)
+	key := "videos/landscape/" + video.ID.String() + ".mp4"
+	if err := cfg.videoStore.Put(context.Background(), key, strings.NewReader("0123456789abcdef"), "video/mp4"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.db.SetVideoFiles(video.ID, cfg.videoStore.URL(key), "video/mp4", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	video, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg, video
+}
+
+func serveStream(cfg *apiConfig, video database.Video, target string, headers map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", target, nil)
+	r.SetPathValue("videoID", video.ID.String())
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	cfg.handlerVideoStream(w, r)
+	return w
+}
+
+func TestHandlerVideoStream(t *testing.T) {
+	cfg, video := newStreamTestConfig(t)
+	signed := *cfg.streamURL(video)
+	token, err := auth.MakeJWT(video.UserID, cfg.jwtSecret, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner := map[string]string{"Authorization": "Bearer " + token}
+
+	w := serveStream(cfg, video, signed, map[string]string{"Range": "bytes=2-5"})
+	if w.Code != http.StatusPartialContent || w.Body.String() != "2345" {
+		t.Fatalf("ranged request = %d %q, want 206 2345", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Content-Range"); got != "bytes 2-5/16" {
+		t.Errorf("Content-Range = %q", got)
+	}
+	if got := w.Header().Get("Content-Type"); got != "video/mp4" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if got := w.Header().Get("Cache-Control"); got != "private, no-cache" {
+		t.Errorf("private video has Cache-Control %q", got)
+	}
+	etag := w.Header().Get("ETag")
+
+	w = serveStream(cfg, video, signed, map[string]string{"Range": "bytes=-3"})
+	if w.Code != http.StatusPartialContent || w.Body.String() != "def" {
+		t.Errorf("suffix range = %d %q, want 206 def", w.Code, w.Body.String())
+	}
+
+	w = serveStream(cfg, video, signed, map[string]string{"Range": "bytes=10-", "If-Range": etag})
+	if w.Code != http.StatusPartialContent || w.Body.String() != "abcdef" {
+		t.Errorf("If-Range with the current ETag = %d %q, want 206 abcdef", w.Code, w.Body.String())
+	}
+	w = serveStream(cfg, video, signed, map[string]string{"Range": "bytes=10-", "If-Range": `"old"`})
+	if w.Code != http.StatusOK || w.Body.String() != "0123456789abcdef" {
+		t.Errorf("If-Range with a stale ETag = %d %q, want the whole file", w.Code, w.Body.String())
+	}
+
+	w = serveStream(cfg, video, signed, map[string]string{"Range": "bytes=20-"})
+	if w.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("range past the end = %d, want 416", w.Code)
+	}
+
+	w = serveStream(cfg, video, "/api/videos/"+video.ID.String()+"/stream", owner)
+	if w.Code != http.StatusOK || w.Body.String() != "0123456789abcdef" {
+		t.Errorf("owner's request = %d %q, want the whole file", w.Code, w.Body.String())
+	}
+}
+
+func TestHandlerVideoStreamRejects(t *testing.T) {
+	cfg, video := newStreamTestConfig(t)
+	path := "/api/videos/" + video.ID.String() + "/stream"
+
+	if w := serveStream(cfg, video, path, nil); w.Code != http.StatusNotFound {
+		t.Errorf("anonymous request for a private video = %d, want 404", w.Code)
+	}
+
+	tampered := strings.Replace(*cfg.streamURL(video), "sig=", "sig=x", 1)
+	if w := serveStream(cfg, video, tampered, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("tampered signature = %d, want 401", w.Code)
+	}
+
+	// A signature is only good for the path it was made for
+	other := createTestVideo(t, cfg.db)
+	other.VideoURL = video.VideoURL
+	otherURL := *cfg.streamURL(other)
+	_, query, _ := strings.Cut(otherURL, "?")
+	if w := serveStream(cfg, video, path+"?"+query, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("another video's signature = %d, want 401", w.Code)
+	}
+
+	expired := path + "?" + auth.SignPath(path, cfg.jwtSecret, time.Now().Add(-time.Second)).Encode()
+	if w := serveStream(cfg, video, expired, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("expired signature = %d, want 401", w.Code)
+	}
+
+	stranger, err := auth.MakeJWT(uuid.New(), cfg.jwtSecret, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := serveStream(cfg, video, path, map[string]string{"Authorization": "Bearer " + stranger}); w.Code != http.StatusNotFound {
+		t.Errorf("someone else's request for a private video = %d, want 404", w.Code)
+	}
+}
+
+func TestHandlerVideoGetPrivateETag(t *testing.T) {
+	cfg, video := newStreamTestConfig(t)
+	token, err := auth.MakeJWT(video.UserID, cfg.jwtSecret, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(headers map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/api/videos/"+video.ID.String(), nil)
+		r.SetPathValue("videoID", video.ID.String())
+		r.Header.Set("Authorization", "Bearer "+token)
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		cfg.handlerVideoGet(w, r)
+		return w
+	}
+
+	w := get(nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET = %d", w.Code)
+	}
+	etag := w.Header().Get("ETag")
+	if etag == "" || w.Header().Get("Last-Modified") != "" || w.Header().Get("Cache-Control") != "private, no-cache" {
+		t.Errorf("private video headers = %v, want an ETag, no Last-Modified and private, no-cache", w.Header())
+	}
+
+	if w := get(map[string]string{"If-None-Match": etag}); w.Code != http.StatusNotModified {
+		t.Errorf("revalidating with the ETag = %d, want 304", w.Code)
+	}
+	// Its signed URLs may have expired whatever the video's age
+	future := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	if w := get(map[string]string{"If-Modified-Since": future}); w.Code != http.StatusOK {
+		t.Errorf("If-Modified-Since on a private video = %d, want 200", w.Code)
+	}
+}
+func TestStreamURL(t *testing.T) {
+	cfg := &apiConfig{jwtSecret: "secret", signedURLTTL: time.Hour}
+	file := "https://bucket.example.com/videos/abc.mp4"
+	video := database.Video{ID: uuid.New(), VideoURL: &file}
+
+	if cfg.streamURL(database.Video{ID: video.ID}) != nil {
+		t.Errorf("a video without a file has a stream URL")
+	}
+
+	video.Visibility = database.VideoVisibilityPublic
+	path := "/api/videos/" + video.ID.String() + "/stream"
+	if got := cfg.streamURL(video); got == nil || *got != path {
+		t.Errorf("public stream URL = %v, want %s", got, path)
+	}
+
+	video.Visibility = database.VideoVisibilityPrivate
+	got := cfg.streamURL(video)
+	if got == nil {
+		t.Fatal("private video has no stream URL")
+	}
+	signedPath, rawQuery, _ := strings.Cut(*got, "?")
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signedPath != path {
+		t.Errorf("private stream URL %s isn't for %s", *got, path)
+	}
+	if err := auth.ValidatePathSignature(path, query, cfg.jwtSecret); err != nil {
+		t.Errorf("private stream URL's signature doesn't validate: %v", err)
+	}
+}
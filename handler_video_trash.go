@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// trashPurgeInterval is how often the trash is checked for videos past their retention
+const trashPurgeInterval = time.Hour
+
+type trashedVideo struct {
+	database.Video
+	// PurgesAt is when the video and its files will be deleted for good
+	PurgesAt time.Time `json:"purges_at"`
+}
+
+// handlerVideosTrash lists the caller's trashed videos, most recently deleted first
+func (cfg *apiConfig) handlerVideosTrash(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	videos, err := cfg.db.GetTrashedVideos(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve trash", err)
+		return
+	}
+
+	trashed := make([]trashedVideo, 0, len(videos))
+	for _, video := range videos {
+		trashed = append(trashed, trashedVideo{
+			Video:    video,
+			PurgesAt: video.DeletedAt.Add(cfg.trashRetention),
+		})
+	}
+	respondWithJSON(w, http.StatusOK, struct {
+		Videos []trashedVideo `json:"videos"`
+	}{trashed})
+}
+
+// handlerVideoRestore takes a video back out of the trash
+func (cfg *apiConfig) handlerVideoRestore(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideoWithTrashed(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't restore this video", nil)
+		return
+	}
+	if video.DeletedAt == nil {
+		respondWithError(w, http.StatusConflict, "Video isn't in the trash", nil)
+		return
+	}
+
+	if err := cfg.db.RestoreVideo(videoID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't restore video", err)
+		return
+	}
+	video, err = cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// startTrashPurge permanently deletes videos that have been in the trash
+// longer than the retention period, until ctx is cancelled
+func (cfg *apiConfig) startTrashPurge(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(trashPurgeInterval)
+		defer ticker.Stop()
+		for {
+			cfg.purgeTrash()
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (cfg *apiConfig) purgeTrash() {
+	cutoff := time.Now().Add(-cfg.trashRetention)
+	videos, err := cfg.db.GetVideosTrashedBefore(cutoff)
+	if err != nil {
+		log.Printf("Couldn't get videos to purge from the trash: %v", err)
+		return
+	}
+	purged := 0
+	for _, video := range videos {
+		// Checked again by the delete in case the video was restored since
+		deleted, err := cfg.db.PurgeTrashedVideo(video.ID, cutoff)
+		if err != nil {
+			log.Printf("Couldn't purge video %s: %v", video.ID, err)
+			continue
+		}
+		if !deleted {
+			continue
+		}
+		cfg.enqueueVideoObjectDeletion(video)
+		purged++
+	}
+	if purged > 0 {
+		log.Printf("Purged %d videos from the trash", purged)
+	}
+}
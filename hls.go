@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// rendition is one rung of the adaptive bitrate ladder.
+// size is the length of the short side, so the same ladder
+// works for landscape and portrait videos.
+type rendition struct {
+	name         string
+	size         int
+	videoBitrate int // kbps
+	audioBitrate int // kbps
+	// level is the H.264 level times ten, high enough for 60 fps at the rendition's size
+	level int
+}
+
+var renditionLadder = []rendition{
+	{name: "1080p", size: 1080, videoBitrate: 5000, audioBitrate: 192, level: 42},
+	{name: "720p", size: 720, videoBitrate: 2800, audioBitrate: 128, level: 32},
+	{name: "480p", size: 480, videoBitrate: 1400, audioBitrate: 128, level: 31},
+	{name: "360p", size: 360, videoBitrate: 800, audioBitrate: 96, level: 31},
+}
+
+// renditionsFor drops the rungs that would upscale the source.
+// The smallest rung is always kept so every video gets at least one rendition.
+func renditionsFor(width, height int) []rendition {
+	shortSide := min(width, height)
+	renditions := []rendition{}
+	for _, r := range renditionLadder {
+		if r.size <= shortSide {
+			renditions = append(renditions, r)
+		}
+	}
+	if len(renditions) == 0 {
+		renditions = append(renditions, renditionLadder[len(renditionLadder)-1])
+	}
+	return renditions
+}
+
+// scaledDimensions returns the output size of a rendition, keeping the
+// source aspect ratio and rounding to even numbers as libx264 requires.
+func (r rendition) scaledDimensions(width, height int) (int, int) {
+	if width >= height {
+		return evenRound(float64(width) * float64(r.size) / float64(height)), r.size
+	}
+	return r.size, evenRound(float64(height) * float64(r.size) / float64(width))
+}
+
+// x264Args returns the ffmpeg arguments that scale and encode one rendition.
+// Keyframes are forced every 2 seconds so segments line up across renditions.
+func (r rendition) x264Args(width, height int) []string {
+	outWidth, outHeight := r.scaledDimensions(width, height)
+	return []string{
+		"-vf", fmt.Sprintf("scale=%d:%d", outWidth, outHeight),
+		"-c:v", "libx264",
+		"-preset", "veryfast",
+		"-profile:v", "main",
+		"-level:v", fmt.Sprintf("%d.%d", r.level/10, r.level%10),
+		"-b:v", fmt.Sprintf("%dk", r.videoBitrate),
+		"-maxrate", fmt.Sprintf("%dk", r.videoBitrate*107/100),
+		"-bufsize", fmt.Sprintf("%dk", r.videoBitrate*3/2),
+		"-force_key_frames", "expr:gte(t,n_forced*2)",
+		"-sc_threshold", "0",
+		"-c:a", "aac",
+		"-b:a", fmt.Sprintf("%dk", r.audioBitrate),
+		"-ac", "2",
+	}
+}
+
+// codecs is the RFC 6381 CODECS attribute for what x264Args encodes: H.264
+// Main (profile 0x4d, which x264 flags as also Main compatible, 0x40) at
+// the rendition's level, and AAC-LC audio
+func (r rendition) codecs() string {
+	return fmt.Sprintf("avc1.4d40%02x,mp4a.40.2", r.level)
+}
+
+func evenRound(f float64) int {
+	n := int(f + 0.5)
+	if n%2 == 1 {
+		n++
+	}
+	return n
+}
+
+// packageHLS transcodes the video into the HLS ladder, uploads every playlist
+// and segment under <keyPrefix>/hls/ and returns the master playlist URL.
+func (cfg *apiConfig) packageHLS(ctx context.Context, filePath, keyPrefix string, duration time.Duration, onProgress func(float64)) (string, error) {
+	width, height, err := getVideoDimensions(filePath)
+	if err != nil {
+		return "", err
+	}
+
+	outputDir, err := os.MkdirTemp("", "tubely-hls-")
+	if err != nil {
+		return "", fmt.Errorf("couldn't create HLS output directory: %w", err)
+	}
+	defer os.RemoveAll(outputDir)
+
+	var master strings.Builder
+	master.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	renditions := renditionsFor(width, height)
+	for i, r := range renditions {
+		renditionDir := filepath.Join(outputDir, r.name)
+		if err := os.MkdirAll(renditionDir, 0755); err != nil {
+			return "", fmt.Errorf("couldn't create HLS output directory: %w", err)
+		}
+
+		args := []string{"-i", filePath}
+		args = append(args, r.x264Args(width, height)...)
+		args = append(args,
+			"-f", "hls",
+			"-hls_time", "6",
+			"-hls_playlist_type", "vod",
+			"-hls_segment_filename", filepath.Join(renditionDir, "segment_%03d.ts"),
+			filepath.Join(renditionDir, "index.m3u8"),
+		)
+		// Renditions are encoded one after another, so each is an equal slice of the progress
+		renditionProgress := func(fraction float64) {
+			if onProgress != nil {
+				onProgress((float64(i) + fraction) / float64(len(renditions)))
+			}
+		}
+		if err := runFFmpeg(ctx, duration, renditionProgress, args...); err != nil {
+			return "", fmt.Errorf("error transcoding %s rendition: %v", r.name, err)
+		}
+
+		outWidth, outHeight := r.scaledDimensions(width, height)
+		bandwidth := (r.videoBitrate + r.audioBitrate) * 1000
+		fmt.Fprintf(&master, "#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d,CODECS=\"%s\"\n", bandwidth, outWidth, outHeight, r.codecs())
+		fmt.Fprintf(&master, "%s/index.m3u8\n", r.name)
+	}
+
+	err = os.WriteFile(filepath.Join(outputDir, "master.m3u8"), []byte(master.String()), 0644)
+	if err != nil {
+		return "", fmt.Errorf("couldn't write master playlist: %w", err)
+	}
+
+	hlsPrefix := path.Join(keyPrefix, "hls")
+	if err := cfg.uploadDirectory(ctx, outputDir, hlsPrefix); err != nil {
+		return "", err
+	}
+	return cfg.videoStore.URL(path.Join(hlsPrefix, "master.m3u8")), nil
+}
+
+// uploadDirectory puts every file under dir into the video store, keyed by
+// its path relative to dir under keyPrefix.
+func (cfg *apiConfig) uploadDirectory(ctx context.Context, dir, keyPrefix string) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		key := path.Join(keyPrefix, filepath.ToSlash(rel))
+
+		f, err := os.Open(p)
+		if err != nil {
+			return fmt.Errorf("couldn't open %s: %w", p, err)
+		}
+		defer f.Close()
+		return cfg.videoStore.Put(ctx, key, f, streamingContentType(key))
+	})
+}
+
+func streamingContentType(key string) string {
+	switch path.Ext(key) {
+	case ".m3u8":
+		return "application/vnd.apple.mpegurl"
+	case ".ts":
+		return "video/mp2t"
+	case ".mpd":
+		return "application/dash+xml"
+	case ".m4s":
+		return "video/iso.segment"
+	}
+	if t := mime.TypeByExtension(path.Ext(key)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
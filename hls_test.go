@@ -0,0 +1,84 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestRenditionsFor(t *testing.T) {
+	names := func(renditions []rendition) []string {
+		var names []string
+		for _, r := range renditions {
+			names = append(names, r.name)
+		}
+		return names
+	}
+	tests := []struct {
+		width, height int
+		want          []string
+	}{
+		{1920, 1080, []string{"1080p", "720p", "480p", "360p"}},
+		{1080, 1920, []string{"1080p", "720p", "480p", "360p"}},
+		{1280, 720, []string{"720p", "480p", "360p"}},
+		{1000, 600, []string{"480p", "360p"}},
+		{320, 240, []string{"360p"}},
+	}
+	for _, tt := range tests {
+		if got := names(renditionsFor(tt.width, tt.height)); !slices.Equal(got, tt.want) {
+			t.Errorf("renditionsFor(%d, %d) = %v, want %v", tt.width, tt.height, got, tt.want)
+		}
+	}
+}
+
+func TestScaledDimensions(t *testing.T) {
+	r := rendition{size: 480}
+	tests := []struct {
+		width, height       int
+		outWidth, outHeight int
+	}{
+		{1920, 1080, 854, 480},
+		{1080, 1920, 480, 854},
+		{1440, 1080, 640, 480},
+		{1000, 1000, 480, 480},
+	}
+	for _, tt := range tests {
+		w, h := r.scaledDimensions(tt.width, tt.height)
+		if w != tt.outWidth || h != tt.outHeight {
+			t.Errorf("scaledDimensions(%d, %d) = %dx%d, want %dx%d", tt.width, tt.height, w, h, tt.outWidth, tt.outHeight)
+		}
+		if w%2 != 0 || h%2 != 0 {
+			t.Errorf("scaledDimensions(%d, %d) = %dx%d, libx264 needs even sizes", tt.width, tt.height, w, h)
+		}
+	}
+}
+
+func TestRenditionLevels(t *testing.T) {
+	// Maximum frame size in macroblocks and macroblocks per second of the
+	// H.264 levels the ladder uses (ITU-T H.264 table A-1)
+	limits := map[int]struct{ frameSize, perSecond int }{
+		31: {3600, 108000},
+		32: {5120, 216000},
+		42: {8704, 522240},
+	}
+	for _, r := range renditionLadder {
+		limit, ok := limits[r.level]
+		if !ok {
+			t.Errorf("%s: level %d isn't in the table", r.name, r.level)
+			continue
+		}
+		// A 16:9 source at 60 fps
+		w, h := r.scaledDimensions(1920, 1080)
+		macroblocks := ((w + 15) / 16) * ((h + 15) / 16)
+		if macroblocks > limit.frameSize || macroblocks*60 > limit.perSecond {
+			t.Errorf("%s: %dx%d at 60 fps is beyond level %d", r.name, w, h, r.level)
+		}
+	}
+
+	codecs := map[string]string{}
+	for _, r := range renditionLadder {
+		codecs[r.name] = r.codecs()
+	}
+	if codecs["720p"] != "avc1.4d4020,mp4a.40.2" || codecs["1080p"] != "avc1.4d402a,mp4a.40.2" {
+		t.Errorf("codecs = %v", codecs)
+	}
+}
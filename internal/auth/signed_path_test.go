@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestValidatePathSignature(t *testing.T) {
+	const secret = "secret"
+	path := "/api/videos/5c6f4bd2-8b4f-4f2a-9a57-5a1f0a4b6d10/events"
+	query := SignPath(path, secret, time.Now().Add(time.Minute))
+
+	if err := ValidatePathSignature(path, query, secret); err != nil {
+		t.Fatalf("valid signature rejected: %v", err)
+	}
+	if err := ValidatePathSignature(path+"x", query, secret); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("signature for another path: error = %v, want ErrInvalidSignature", err)
+	}
+	if err := ValidatePathSignature(path, query, "other secret"); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("signature with another secret: error = %v, want ErrInvalidSignature", err)
+	}
+
+	tampered := SignPath(path, secret, time.Now().Add(time.Minute))
+	tampered.Set("exp", SignPath(path, secret, time.Now().Add(time.Hour)).Get("exp"))
+	if err := ValidatePathSignature(path, tampered, secret); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("extended expiry: error = %v, want ErrInvalidSignature", err)
+	}
+
+	for _, key := range []string{"exp", "sig"} {
+		missing := SignPath(path, secret, time.Now().Add(time.Minute))
+		missing.Del(key)
+		if err := ValidatePathSignature(path, missing, secret); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("without %s: error = %v, want ErrInvalidSignature", key, err)
+		}
+	}
+}
+
+func TestValidatePathSignatureExpired(t *testing.T) {
+	query := SignPath("/api/videos/1/stream", "secret", time.Now().Add(-time.Second))
+	if err := ValidatePathSignature("/api/videos/1/stream", query, "secret"); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("expired signature: error = %v, want ErrInvalidSignature", err)
+	}
+}
@@ -0,0 +1,37 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// newTestClient opens a fresh, fully migrated SQLite database that's removed after the test
+func newTestClient(t *testing.T) Client {
+	t.Helper()
+	c, err := NewClient(filepath.Join(t.TempDir(), "tubely.db"))
+	if err != nil {
+		t.Fatalf("couldn't open test database: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func createTestUser(t *testing.T, c Client) *User {
+	t.Helper()
+	user, err := c.CreateUser(CreateUserParams{Email: uuid.NewString() + "@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatalf("couldn't create user: %v", err)
+	}
+	return user
+}
+
+func createTestVideo(t *testing.T, c Client, params CreateVideoParams) Video {
+	t.Helper()
+	video, err := c.CreateVideo(params)
+	if err != nil {
+		t.Fatalf("couldn't create video: %v", err)
+	}
+	return video
+}
@@ -0,0 +1,111 @@
+package database
+
+import (
+	"database/sql"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dialect is the SQL flavour of the database behind a Client.
+// Queries are written for SQLite with ? placeholders and rebound for Postgres.
+type dialect string
+
+const (
+	dialectSQLite   dialect = "sqlite3"
+	dialectPostgres dialect = "postgres"
+)
+
+// parseDatabaseURL picks the driver from the URL scheme. postgres:// and postgresql://
+// URLs go to Postgres, anything else is a SQLite path (optionally prefixed with sqlite://).
+func parseDatabaseURL(dbURL string) (dialect, string) {
+	switch {
+	case strings.HasPrefix(dbURL, "postgres://"), strings.HasPrefix(dbURL, "postgresql://"):
+		return dialectPostgres, dbURL
+	case strings.HasPrefix(dbURL, "sqlite://"):
+		return dialectSQLite, strings.TrimPrefix(dbURL, "sqlite://")
+	case strings.HasPrefix(dbURL, "sqlite3://"):
+		return dialectSQLite, strings.TrimPrefix(dbURL, "sqlite3://")
+	}
+	return dialectSQLite, dbURL
+}
+
+// rebind rewrites ? placeholders to Postgres' $1, $2, ..., leaving quoted strings alone
+func (d dialect) rebind(query string) string {
+	if d != dialectPostgres || !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+	n := 0
+	inString := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			inString = !inString
+		case r == '?' && !inString:
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+var (
+	timestampType = regexp.MustCompile(`\bTIMESTAMP\b`)
+	realType      = regexp.MustCompile(`\bREAL\b`)
+	integerType   = regexp.MustCompile(`\bINTEGER\b`)
+)
+
+// ddl adapts a CREATE TABLE statement written for SQLite to the dialect.
+// Postgres gets time zone aware timestamps, double precision floats and
+// 64-bit integers (SQLite's INTEGER already is).
+func (d dialect) ddl(query string) string {
+	if d != dialectPostgres {
+		return query
+	}
+	query = timestampType.ReplaceAllString(query, "TIMESTAMPTZ")
+	query = realType.ReplaceAllString(query, "DOUBLE PRECISION")
+	return integerType.ReplaceAllString(query, "BIGINT")
+}
+
+// timeParam formats a time for comparison with timestamp columns. SQLite stores
+// them as UTC text, "YYYY-MM-DD HH:MM:SS" with milliseconds when there are any
+// (see now), and compares them as strings.
+func (d dialect) timeParam(t time.Time) any {
+	if d == dialectSQLite {
+		t = t.UTC().Truncate(time.Millisecond)
+		if t.Nanosecond() == 0 {
+			return t.Format("2006-01-02 15:04:05")
+		}
+		return t.Format("2006-01-02 15:04:05.000")
+	}
+	return t
+}
+
+// now is the SQL for the current time, for columns like updated_at that are
+// compared as versions. SQLite's CURRENT_TIMESTAMP only has whole seconds,
+// so it gets milliseconds in the format timeParam produces.
+func (d dialect) now() string {
+	if d == dialectSQLite {
+		return `replace(strftime('%Y-%m-%d %H:%M:%f', 'now'), '.000', '')`
+	}
+	return "CURRENT_TIMESTAMP"
+}
+
+func (c Client) exec(query string, args ...any) (sql.Result, error) {
+	return c.db.Exec(c.dialect.rebind(query), args...)
+}
+
+func (c Client) query(query string, args ...any) (*sql.Rows, error) {
+	return c.db.Query(c.dialect.rebind(query), args...)
+}
+
+func (c Client) queryRow(query string, args ...any) *sql.Row {
+	return c.db.QueryRow(c.dialect.rebind(query), args...)
+}
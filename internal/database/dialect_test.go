@@ -0,0 +1,94 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDatabaseURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		dialect dialect
+		dsn     string
+	}{
+		{"tubely.db", dialectSQLite, "tubely.db"},
+		{"sqlite://data/tubely.db", dialectSQLite, "data/tubely.db"},
+		{"sqlite3:///var/lib/tubely.db", dialectSQLite, "/var/lib/tubely.db"},
+		{"postgres://u:p@localhost/tubely", dialectPostgres, "postgres://u:p@localhost/tubely"},
+		{"postgresql://localhost/tubely?sslmode=disable", dialectPostgres, "postgresql://localhost/tubely?sslmode=disable"},
+	}
+	for _, tt := range tests {
+		d, dsn := parseDatabaseURL(tt.url)
+		if d != tt.dialect || dsn != tt.dsn {
+			t.Errorf("parseDatabaseURL(%q) = %s, %q, want %s, %q", tt.url, d, dsn, tt.dialect, tt.dsn)
+		}
+	}
+}
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"SELECT 1", "SELECT 1"},
+		{"SELECT * FROM videos WHERE id = ? AND user_id = ?", "SELECT * FROM videos WHERE id = $1 AND user_id = $2"},
+		{"UPDATE videos SET title = ? WHERE title = 'who?' AND id = ?", "UPDATE videos SET title = $1 WHERE title = 'who?' AND id = $2"},
+		{"SELECT 'it''s?' WHERE a = ?", "SELECT 'it''s?' WHERE a = $1"},
+		{"SELECT 'café?', ?", "SELECT 'café?', $1"},
+	}
+	for _, tt := range tests {
+		if got := dialectPostgres.rebind(tt.query); got != tt.want {
+			t.Errorf("rebind(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+		if got := dialectSQLite.rebind(tt.query); got != tt.query {
+			t.Errorf("SQLite rebind(%q) = %q, want it unchanged", tt.query, got)
+		}
+	}
+}
+
+func TestDDL(t *testing.T) {
+	query := `CREATE TABLE t (id TEXT, at TIMESTAMP, n INTEGER, f REAL, integers_seen TEXT)`
+	want := `CREATE TABLE t (id TEXT, at TIMESTAMPTZ, n BIGINT, f DOUBLE PRECISION, integers_seen TEXT)`
+	if got := dialectPostgres.ddl(query); got != want {
+		t.Errorf("Postgres ddl = %q, want %q", got, want)
+	}
+	if got := dialectSQLite.ddl(query); got != query {
+		t.Errorf("SQLite ddl = %q, want it unchanged", got)
+	}
+}
+
+func TestTimeParam(t *testing.T) {
+	zone := time.FixedZone("UTC+2", 2*60*60)
+	whole := time.Date(2024, 3, 1, 12, 30, 5, 0, zone)
+	fractional := time.Date(2024, 3, 1, 12, 30, 5, 123_456_789, zone)
+
+	if got := dialectSQLite.timeParam(whole); got != "2024-03-01 10:30:05" {
+		t.Errorf("SQLite timeParam(whole second) = %v", got)
+	}
+	if got := dialectSQLite.timeParam(fractional); got != "2024-03-01 10:30:05.123" {
+		t.Errorf("SQLite timeParam(fraction) = %v", got)
+	}
+	if got, ok := dialectPostgres.timeParam(fractional).(time.Time); !ok || !got.Equal(fractional) {
+		t.Errorf("Postgres timeParam = %v, want the time as is", got)
+	}
+}
+
+// SQLite compares timestamps as text, so now() has to produce what timeParam does
+func TestNowMatchesTimeParam(t *testing.T) {
+	c := newTestClient(t)
+	before := time.Now().UTC().Truncate(time.Millisecond)
+	var now string
+	if err := c.queryRow(`SELECT ` + c.dialect.now()).Scan(&now); err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := time.Parse("2006-01-02 15:04:05.999", now)
+	if err != nil {
+		t.Fatalf("now() = %q, not in timeParam's format: %v", now, err)
+	}
+	if parsed.Before(before) || parsed.After(time.Now().UTC()) {
+		t.Errorf("now() = %v, want a time between %v and now", parsed, before)
+	}
+	if formatted := c.dialect.timeParam(parsed); formatted != now {
+		t.Errorf("timeParam(now()) = %v, want %q", formatted, now)
+	}
+}
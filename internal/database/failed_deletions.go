@@ -0,0 +1,63 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// FailedDeletion is a stored object that couldn't be deleted along with its video.
+// It stays recorded until a later cleanup manages to delete it.
+type FailedDeletion struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	// Store names the blob store holding the object, "video" or "asset"
+	Store string `json:"store"`
+	// Key ending in a slash stands for everything under that prefix
+	Key     string    `json:"key"`
+	VideoID uuid.UUID `json:"video_id"`
+	Error   string    `json:"error"`
+}
+
+// RecordFailedDeletion remembers an object that still needs deleting.
+// Recording the same object again only updates the error.
+func (c Client) RecordFailedDeletion(store, key string, videoID uuid.UUID, deleteErr string) error {
+	query := `
+	INSERT INTO failed_deletions (id, created_at, store, object_key, video_id, error)
+	VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?)
+	ON CONFLICT (store, object_key) DO UPDATE SET error = excluded.error
+	`
+	_, err := c.exec(query, uuid.New(), store, key, videoID, deleteErr)
+	return err
+}
+
+// GetFailedDeletions returns the recorded objects, oldest first
+func (c Client) GetFailedDeletions(limit int) ([]FailedDeletion, error) {
+	query := `
+	SELECT id, created_at, store, object_key, video_id, error
+	FROM failed_deletions
+	ORDER BY created_at, id
+	LIMIT ?
+	`
+	rows, err := c.query(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deletions := []FailedDeletion{}
+	for rows.Next() {
+		var d FailedDeletion
+		if err := rows.Scan(&d.ID, &d.CreatedAt, &d.Store, &d.Key, &d.VideoID, &d.Error); err != nil {
+			return nil, err
+		}
+		deletions = append(deletions, d)
+	}
+	return deletions, rows.Err()
+}
+
+// ResolveFailedDeletion forgets an object once it has been deleted
+func (c Client) ResolveFailedDeletion(id uuid.UUID) error {
+	_, err := c.exec(`DELETE FROM failed_deletions WHERE id = ?`, id)
+	return err
+}
@@ -0,0 +1,216 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type JobStatus string
+
+const (
+	JobStatusQueued    JobStatus = "queued"
+	JobStatusRunning   JobStatus = "running"
+	JobStatusSucceeded JobStatus = "succeeded"
+	JobStatusFailed    JobStatus = "failed"
+)
+
+type Job struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Status    JobStatus `json:"status"`
+	Attempts  int       `json:"attempts"`
+	RunAt     time.Time `json:"run_at"`
+	LastError *string   `json:"last_error"`
+	CreateJobParams
+}
+
+type CreateJobParams struct {
+	Type        string    `json:"type"`
+	UserID      uuid.UUID `json:"user_id"`
+	Payload     string    `json:"-"`
+	MaxAttempts int       `json:"max_attempts"`
+}
+
+func (c Client) CreateJob(params CreateJobParams) (Job, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO jobs (
+		id,
+		created_at,
+		updated_at,
+		type,
+		user_id,
+		payload,
+		status,
+		attempts,
+		max_attempts,
+		run_at
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, 0, ?, ?)
+	`
+	_, err := c.exec(
+		query,
+		id,
+		params.Type,
+		params.UserID,
+		params.Payload,
+		JobStatusQueued,
+		params.MaxAttempts,
+		time.Now().UTC(),
+	)
+	if err != nil {
+		return Job{}, err
+	}
+
+	return c.GetJob(id)
+}
+
+func (c Client) GetJob(id uuid.UUID) (Job, error) {
+	query := `
+	SELECT
+		id,
+		created_at,
+		updated_at,
+		type,
+		user_id,
+		payload,
+		status,
+		attempts,
+		max_attempts,
+		run_at,
+		last_error
+	FROM jobs
+	WHERE id = ?
+	`
+
+	var job Job
+	err := c.queryRow(query, id).Scan(
+		&job.ID,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+		&job.Type,
+		&job.UserID,
+		&job.Payload,
+		&job.Status,
+		&job.Attempts,
+		&job.MaxAttempts,
+		&job.RunAt,
+		&job.LastError,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Job{}, nil
+		}
+		return Job{}, err
+	}
+
+	return job, nil
+}
+
+// ClaimNextJob marks the oldest due job as running and returns it.
+// It returns nil when there's nothing to do.
+func (c Client) ClaimNextJob() (*Job, error) {
+	for {
+		var id uuid.UUID
+		err := c.queryRow(`
+		SELECT id
+		FROM jobs
+		WHERE status = ? AND run_at <= ?
+		ORDER BY run_at
+		LIMIT 1
+		`, JobStatusQueued, time.Now().UTC()).Scan(&id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := c.exec(`
+		UPDATE jobs
+		SET
+			status = ?,
+			attempts = attempts + 1,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND status = ?
+		`, JobStatusRunning, id, JobStatusQueued)
+		if err != nil {
+			return nil, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			// Another worker claimed it first, try the next one
+			continue
+		}
+
+		job, err := c.GetJob(id)
+		if err != nil {
+			return nil, err
+		}
+		return &job, nil
+	}
+}
+
+func (c Client) CompleteJob(id uuid.UUID) error {
+	query := `
+	UPDATE jobs
+	SET
+		status = ?,
+		last_error = NULL,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.exec(query, JobStatusSucceeded, id)
+	return err
+}
+
+// RetryJob puts a failed job back in the queue to run again at runAt
+func (c Client) RetryJob(id uuid.UUID, jobErr string, runAt time.Time) error {
+	query := `
+	UPDATE jobs
+	SET
+		status = ?,
+		last_error = ?,
+		run_at = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.exec(query, JobStatusQueued, jobErr, runAt.UTC(), id)
+	return err
+}
+
+func (c Client) FailJob(id uuid.UUID, jobErr string) error {
+	query := `
+	UPDATE jobs
+	SET
+		status = ?,
+		last_error = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.exec(query, JobStatusFailed, jobErr, id)
+	return err
+}
+
+// RequeueRunningJobs puts jobs that were running when the server
+// stopped back in the queue. Call it before starting any workers.
+func (c Client) RequeueRunningJobs() (int64, error) {
+	query := `
+	UPDATE jobs
+	SET
+		status = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE status = ?
+	`
+	res, err := c.exec(query, JobStatusQueued, JobStatusRunning)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
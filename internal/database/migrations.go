@@ -0,0 +1,555 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// migration is one versioned schema change. Versions are applied in order and
+// recorded in schema_migrations, each in its own transaction.
+type migration struct {
+	version int
+	name    string
+	up      func(tx migrationTx) error
+	down    func(tx migrationTx) error
+}
+
+// migrationTx is the transaction a migration runs in, rebinding its queries for the dialect
+type migrationTx struct {
+	tx      *sql.Tx
+	dialect dialect
+}
+
+func (t migrationTx) Exec(query string, args ...any) (sql.Result, error) {
+	return t.tx.Exec(t.dialect.rebind(query), args...)
+}
+
+func (t migrationTx) Query(query string, args ...any) (*sql.Rows, error) {
+	return t.tx.Query(t.dialect.rebind(query), args...)
+}
+
+// MigrationStatus is a known migration and when it was applied, if it was
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+// migrations must only ever be appended to. The early ones tolerate tables and
+// columns that already exist because databases created before versioned
+// migrations already have some of them.
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "create_users",
+		up: execAll(`
+		CREATE TABLE IF NOT EXISTS users (
+			id TEXT PRIMARY KEY,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			password TEXT NOT NULL,
+			email TEXT UNIQUE NOT NULL
+		)`, `
+		CREATE TABLE IF NOT EXISTS refresh_tokens (
+			token TEXT PRIMARY KEY,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			revoked_at TIMESTAMP,
+			user_id TEXT NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			FOREIGN KEY(user_id) REFERENCES users(id)
+		)`),
+		down: execAll(`DROP TABLE refresh_tokens`, `DROP TABLE users`),
+	},
+	{
+		version: 2,
+		name:    "create_videos",
+		up: func(tx migrationTx) error {
+			// SQLite databases have always had user_id as INTEGER (holding UUID text),
+			// Postgres won't reference users(id) from anything but TEXT
+			userIDType := "INTEGER"
+			if tx.dialect == dialectPostgres {
+				userIDType = "TEXT"
+			}
+			return execAll(`
+			CREATE TABLE IF NOT EXISTS videos (
+				id TEXT PRIMARY KEY,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				title TEXT NOT NULL,
+				description TEXT,
+				thumbnail_url TEXT,
+				video_url TEXT,
+				user_id ` + userIDType + `,
+				FOREIGN KEY(user_id) REFERENCES users(id)
+			)`)(tx)
+		},
+		down: execAll(`DROP TABLE videos`),
+	},
+	{
+		version: 3,
+		name:    "add_video_streaming_urls",
+		up:      addColumns("videos", column{"hls_url", "TEXT"}, column{"dash_url", "TEXT"}),
+		down:    dropColumns("videos", "hls_url", "dash_url"),
+	},
+	{
+		version: 4,
+		name:    "add_video_status",
+		up: func(tx migrationTx) error {
+			added, err := addColumnIfMissing(tx, "videos", "status", "TEXT NOT NULL DEFAULT 'draft'")
+			if err != nil {
+				return err
+			}
+			if added {
+				// Videos uploaded before statuses existed are already processed
+				_, err = tx.Exec("UPDATE videos SET status = 'ready' WHERE video_url IS NOT NULL")
+				if err != nil {
+					return err
+				}
+			}
+			_, err = addColumnIfMissing(tx, "videos", "status_error", "TEXT")
+			return err
+		},
+		down: dropColumns("videos", "status", "status_error"),
+	},
+	{
+		version: 5,
+		name:    "create_video_uploads",
+		up: execAll(`
+		CREATE TABLE IF NOT EXISTS video_uploads (
+			id TEXT PRIMARY KEY,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			completed_at TIMESTAMP,
+			video_id TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			upload_length INTEGER NOT NULL,
+			upload_offset INTEGER NOT NULL DEFAULT 0,
+			media_type TEXT NOT NULL,
+			file_path TEXT NOT NULL,
+			FOREIGN KEY(video_id) REFERENCES videos(id),
+			FOREIGN KEY(user_id) REFERENCES users(id)
+		)`),
+		down: execAll(`DROP TABLE video_uploads`),
+	},
+	{
+		version: 6,
+		name:    "create_jobs",
+		up: execAll(`
+		CREATE TABLE IF NOT EXISTS jobs (
+			id TEXT PRIMARY KEY,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			type TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			status TEXT NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			max_attempts INTEGER NOT NULL,
+			run_at TIMESTAMP NOT NULL,
+			last_error TEXT,
+			FOREIGN KEY(user_id) REFERENCES users(id)
+		)`,
+			`CREATE INDEX IF NOT EXISTS idx_jobs_status_run_at ON jobs(status, run_at)`),
+		down: execAll(`DROP TABLE jobs`),
+	},
+	{
+		version: 7,
+		name:    "add_video_thumbnails",
+		up:      addColumns("videos", column{"thumbnails", "TEXT"}),
+		down:    dropColumns("videos", "thumbnails"),
+	},
+	{
+		version: 8,
+		name:    "add_video_media_types",
+		up:      addColumns("videos", column{"thumbnail_media_type", "TEXT"}, column{"video_media_type", "TEXT"}),
+		down:    dropColumns("videos", "thumbnail_media_type", "video_media_type"),
+	},
+	{
+		version: 9,
+		name:    "add_video_media_metadata",
+		up: addColumns("videos",
+			column{"duration_seconds", "REAL"},
+			column{"width", "INTEGER"},
+			column{"height", "INTEGER"},
+			column{"video_codec", "TEXT"},
+			column{"audio_codec", "TEXT"},
+			column{"bitrate", "INTEGER"},
+			column{"frame_rate", "REAL"},
+			column{"rotation", "INTEGER"},
+			column{"audio_channels", "INTEGER"},
+			column{"file_size", "INTEGER"},
+		),
+		down: dropColumns("videos",
+			"duration_seconds", "width", "height", "video_codec", "audio_codec",
+			"bitrate", "frame_rate", "rotation", "audio_channels", "file_size",
+		),
+	},
+	{
+		version: 10,
+		name:    "add_video_list_indexes",
+		up: func(tx migrationTx) error {
+			added, err := addColumnIfMissing(tx, "videos", "aspect_ratio", "TEXT")
+			if err != nil {
+				return err
+			}
+			if added {
+				// Until now the aspect ratio was only recorded in the key's directory
+				_, err = tx.Exec(`
+				UPDATE videos SET aspect_ratio = CASE
+					WHEN video_url LIKE '%/landscape/%' THEN '16:9'
+					WHEN video_url LIKE '%/portrait/%' THEN '9:16'
+				END`)
+				if err != nil {
+					return err
+				}
+			}
+			return execAll(
+				`CREATE INDEX IF NOT EXISTS idx_videos_user_created ON videos(user_id, created_at, id)`,
+				`CREATE INDEX IF NOT EXISTS idx_videos_user_updated ON videos(user_id, updated_at, id)`,
+				`CREATE INDEX IF NOT EXISTS idx_videos_user_title ON videos(user_id, title, id)`,
+				`CREATE INDEX IF NOT EXISTS idx_videos_user_duration ON videos(user_id, COALESCE(duration_seconds, -1), id)`,
+				`CREATE INDEX IF NOT EXISTS idx_videos_user_aspect_ratio ON videos(user_id, aspect_ratio)`,
+			)(tx)
+		},
+		down: func(tx migrationTx) error {
+			err := execAll(
+				`DROP INDEX idx_videos_user_created`,
+				`DROP INDEX idx_videos_user_updated`,
+				`DROP INDEX idx_videos_user_title`,
+				`DROP INDEX idx_videos_user_duration`,
+				`DROP INDEX idx_videos_user_aspect_ratio`,
+			)(tx)
+			if err != nil {
+				return err
+			}
+			return dropColumns("videos", "aspect_ratio")(tx)
+		},
+	},
+	{
+		version: 11,
+		name:    "add_video_search_index",
+		// SQLite's FTS5 table depends on how the binary was built,
+		// so it's set up by prepareSearch on startup instead
+		up: func(tx migrationTx) error {
+			if tx.dialect != dialectPostgres {
+				return nil
+			}
+			return execAll(`CREATE INDEX IF NOT EXISTS idx_videos_search ON videos USING GIN (` + postgresSearchDocument + `)`)(tx)
+		},
+		down: func(tx migrationTx) error {
+			if tx.dialect != dialectPostgres {
+				return nil
+			}
+			return execAll(`DROP INDEX idx_videos_search`)(tx)
+		},
+	},
+	{
+		version: 12,
+		name:    "create_failed_deletions",
+		up: execAll(`
+		CREATE TABLE IF NOT EXISTS failed_deletions (
+			id TEXT PRIMARY KEY,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			store TEXT NOT NULL,
+			object_key TEXT NOT NULL,
+			video_id TEXT NOT NULL,
+			error TEXT NOT NULL,
+			UNIQUE(store, object_key)
+		)`),
+		down: execAll(`DROP TABLE failed_deletions`),
+	},
+	{
+		version: 13,
+		name:    "add_video_deleted_at",
+		up: func(tx migrationTx) error {
+			if err := addColumns("videos", column{"deleted_at", "TIMESTAMP"})(tx); err != nil {
+				return err
+			}
+			return execAll(`CREATE INDEX IF NOT EXISTS idx_videos_deleted_at ON videos(deleted_at)`)(tx)
+		},
+		down: func(tx migrationTx) error {
+			if err := execAll(`DROP INDEX idx_videos_deleted_at`)(tx); err != nil {
+				return err
+			}
+			return dropColumns("videos", "deleted_at")(tx)
+		},
+	},
+	{
+		version: 14,
+		name:    "add_video_visibility",
+		up: func(tx migrationTx) error {
+			added, err := addColumnIfMissing(tx, "videos", "visibility", "TEXT NOT NULL DEFAULT 'private'")
+			if err != nil {
+				return err
+			}
+			if added {
+				// Anyone with the link could watch videos until now, keep it that way
+				_, err = tx.Exec("UPDATE videos SET visibility = 'unlisted'")
+				if err != nil {
+					return err
+				}
+			}
+			return execAll(`CREATE INDEX IF NOT EXISTS idx_videos_visibility_created ON videos(visibility, created_at, id)`)(tx)
+		},
+		down: func(tx migrationTx) error {
+			if err := execAll(`DROP INDEX idx_videos_visibility_created`)(tx); err != nil {
+				return err
+			}
+			return dropColumns("videos", "visibility")(tx)
+		},
+	},
+}
+
+type column struct {
+	name       string
+	definition string
+}
+
+func execAll(queries ...string) func(tx migrationTx) error {
+	return func(tx migrationTx) error {
+		for _, query := range queries {
+			if _, err := tx.Exec(tx.dialect.ddl(query)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func addColumns(table string, columns ...column) func(tx migrationTx) error {
+	return func(tx migrationTx) error {
+		for _, col := range columns {
+			if _, err := addColumnIfMissing(tx, table, col.name, tx.dialect.ddl(col.definition)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func dropColumns(table string, columns ...string) func(tx migrationTx) error {
+	return func(tx migrationTx) error {
+		for _, col := range columns {
+			if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", table, col)); err != nil {
+				return fmt.Errorf("failed to drop column %s.%s: %w", table, col, err)
+			}
+		}
+		return nil
+	}
+}
+
+// addColumnIfMissing adds a column unless a database from before versioned
+// migrations already has it. It reports whether the column was added.
+func addColumnIfMissing(tx migrationTx, table, column, definition string) (bool, error) {
+	if tx.dialect == dialectPostgres {
+		return addPostgresColumnIfMissing(tx, table, column, definition)
+	}
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid          int
+			name         string
+			columnType   string
+			notNull      int
+			defaultValue sql.NullString
+			primaryKey   int
+		)
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &primaryKey); err != nil {
+			return false, err
+		}
+		if name == column {
+			return false, nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+	rows.Close()
+
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil {
+		return false, fmt.Errorf("failed to add column %s.%s: %w", table, column, err)
+	}
+	return true, nil
+}
+
+func addPostgresColumnIfMissing(tx migrationTx, table, column, definition string) (bool, error) {
+	var exists bool
+	err := tx.tx.QueryRow(tx.dialect.rebind(`
+	SELECT EXISTS (
+		SELECT 1 FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = ? AND column_name = ?
+	)`), table, column).Scan(&exists)
+	if err != nil || exists {
+		return false, err
+	}
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil {
+		return false, fmt.Errorf("failed to add column %s.%s: %w", table, column, err)
+	}
+	return true, nil
+}
+
+func (c Client) ensureMigrationsTable() error {
+	_, err := c.exec(c.dialect.ddl(`
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	)`))
+	return err
+}
+
+func (c Client) appliedMigrations() (map[int]time.Time, error) {
+	if err := c.ensureMigrationsTable(); err != nil {
+		return nil, err
+	}
+	rows, err := c.query(`SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// migrationLockID is the Postgres advisory lock held while migrating,
+// so replicas starting at the same time don't race each other
+const migrationLockID = 7_215_403_118
+
+// lockMigrations blocks until no one else is migrating the database.
+// SQLite locks the whole file on write, so only Postgres needs it.
+func (c Client) lockMigrations() (func(), error) {
+	if c.dialect != dialectPostgres {
+		return func() {}, nil
+	}
+	ctx := context.Background()
+	conn, err := c.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("couldn't lock migrations: %w", err)
+	}
+	return func() {
+		conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLockID)
+		conn.Close()
+	}, nil
+}
+
+// MigrateUp applies every pending migration and returns how many it applied
+func (c Client) MigrateUp() (int, error) {
+	unlock, err := c.lockMigrations()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	applied, err := c.appliedMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, m := range migrations {
+		if _, ok := applied[m.version]; ok {
+			continue
+		}
+		err := c.inTx(func(tx migrationTx) error {
+			if err := m.up(tx); err != nil {
+				return err
+			}
+			_, err := tx.Exec(
+				`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`,
+				m.version, m.name, time.Now().UTC(),
+			)
+			return err
+		})
+		if err != nil {
+			return count, fmt.Errorf("migration %d (%s) failed: %w", m.version, m.name, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+// MigrateDown rolls back the most recently applied migrations, at most steps of them,
+// and returns how many it rolled back
+func (c Client) MigrateDown(steps int) (int, error) {
+	unlock, err := c.lockMigrations()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	applied, err := c.appliedMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for i := len(migrations) - 1; i >= 0 && count < steps; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.version]; !ok {
+			continue
+		}
+		err := c.inTx(func(tx migrationTx) error {
+			if err := m.down(tx); err != nil {
+				return err
+			}
+			_, err := tx.Exec(`DELETE FROM schema_migrations WHERE version = ?`, m.version)
+			return err
+		})
+		if err != nil {
+			return count, fmt.Errorf("rolling back migration %d (%s) failed: %w", m.version, m.name, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+// MigrationStatus lists every known migration in order
+func (c Client) MigrationStatus() ([]MigrationStatus, error) {
+	applied, err := c.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]MigrationStatus, len(migrations))
+	for i, m := range migrations {
+		statuses[i] = MigrationStatus{Version: m.version, Name: m.name}
+		if appliedAt, ok := applied[m.version]; ok {
+			statuses[i].AppliedAt = &appliedAt
+		}
+	}
+	return statuses, nil
+}
+
+func (c Client) inTx(fn func(tx migrationTx) error) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(migrationTx{tx: tx, dialect: c.dialect}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
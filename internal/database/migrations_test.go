@@ -0,0 +1,155 @@
+package database
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestMigrationVersionsAreSequential(t *testing.T) {
+	for i, m := range migrations {
+		if m.version != i+1 {
+			t.Errorf("migration %q has version %d, want %d", m.name, m.version, i+1)
+		}
+		if m.up == nil || m.down == nil {
+			t.Errorf("migration %d (%s) needs both up and down", m.version, m.name)
+		}
+	}
+}
+
+func TestMigrateDownAndUp(t *testing.T) {
+	c := newTestClient(t)
+
+	statuses, err := c.MigrationStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range statuses {
+		if s.AppliedAt == nil {
+			t.Fatalf("migration %d (%s) wasn't applied by NewClient", s.Version, s.Name)
+		}
+	}
+	if n, err := c.MigrateUp(); err != nil || n != 0 {
+		t.Fatalf("MigrateUp on a migrated database = %d, %v, want 0, nil", n, err)
+	}
+
+	n, err := c.MigrateDown(2)
+	if err != nil || n != 2 {
+		t.Fatalf("MigrateDown(2) = %d, %v, want 2, nil", n, err)
+	}
+	statuses, err = c.MigrationStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := statuses[len(statuses)-1]
+	if last.AppliedAt != nil || statuses[len(statuses)-3].AppliedAt == nil {
+		t.Fatalf("after MigrateDown(2) only the last two migrations should be pending, got %+v", statuses)
+	}
+
+	n, err = c.MigrateDown(len(migrations))
+	if err != nil || n != len(migrations)-2 {
+		t.Fatalf("MigrateDown(all) = %d, %v, want %d, nil", n, err, len(migrations)-2)
+	}
+	var tables int
+	if err := c.queryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name IN ('users', 'videos', 'jobs')`).Scan(&tables); err != nil {
+		t.Fatal(err)
+	}
+	if tables != 0 {
+		t.Fatalf("%d tables left after rolling everything back", tables)
+	}
+
+	n, err = c.MigrateUp()
+	if err != nil || n != len(migrations) {
+		t.Fatalf("MigrateUp after rolling back = %d, %v, want %d, nil", n, err, len(migrations))
+	}
+	user := createTestUser(t, c)
+	createTestVideo(t, c, CreateVideoParams{Title: "after", UserID: user.ID})
+}
+
+// A database created before versioned migrations already has users, refresh_tokens
+// and a smaller videos table, which the migrations adopt and backfill
+func TestMigrateUpAdoptsUnversionedDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tubely.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID := uuid.New()
+	uploaded, draft := uuid.New(), uuid.New()
+	for _, stmt := range []string{
+		`CREATE TABLE users (
+			id TEXT PRIMARY KEY,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			password TEXT NOT NULL,
+			email TEXT UNIQUE NOT NULL
+		)`,
+		`CREATE TABLE refresh_tokens (
+			token TEXT PRIMARY KEY,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			revoked_at TIMESTAMP,
+			user_id TEXT NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			FOREIGN KEY(user_id) REFERENCES users(id)
+		)`,
+		`CREATE TABLE videos (
+			id TEXT PRIMARY KEY,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			title TEXT NOT NULL,
+			description TEXT,
+			thumbnail_url TEXT,
+			video_url TEXT TEXT,
+			user_id INTEGER,
+			FOREIGN KEY(user_id) REFERENCES users(id)
+		)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = db.Exec(`INSERT INTO users (id, password, email) VALUES (?, 'hash', 'old@example.com')`, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`
+	INSERT INTO videos (id, title, description, video_url, user_id) VALUES
+		(?, 'uploaded', '', 'https://cdn.example.com/portrait/abc.mp4', ?),
+		(?, 'draft', '', NULL, ?)
+	`, uploaded, userID, draft, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	c, err := NewClient(path)
+	if err != nil {
+		t.Fatalf("migrating an unversioned database: %v", err)
+	}
+	defer c.Close()
+
+	video, err := c.GetVideo(uploaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if video.Status != VideoStatusReady {
+		t.Errorf("uploaded video status = %s, want ready", video.Status)
+	}
+	if video.AspectRatio == nil || *video.AspectRatio != "9:16" {
+		t.Errorf("uploaded video aspect ratio = %v, want 9:16 from its key", video.AspectRatio)
+	}
+	if video.Visibility != VideoVisibilityUnlisted {
+		t.Errorf("existing video visibility = %s, want unlisted", video.Visibility)
+	}
+
+	video, err = c.GetVideo(draft)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if video.Status != VideoStatusDraft {
+		t.Errorf("video without a file has status %s, want draft", video.Status)
+	}
+}
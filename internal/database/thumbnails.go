@@ -0,0 +1,38 @@
+package database
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// ThumbnailSet maps a thumbnail width (as a string, it's a JSON object key)
+// to its URL. It's stored as a JSON column.
+type ThumbnailSet map[string]string
+
+func (t ThumbnailSet) Value() (driver.Value, error) {
+	if len(t) == 0 {
+		return nil, nil
+	}
+	dat, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+	return string(dat), nil
+}
+
+func (t *ThumbnailSet) Scan(src any) error {
+	var dat []byte
+	switch v := src.(type) {
+	case nil:
+		*t = nil
+		return nil
+	case string:
+		dat = []byte(v)
+	case []byte:
+		dat = v
+	default:
+		return fmt.Errorf("can't scan %T into ThumbnailSet", src)
+	}
+	return json.Unmarshal(dat, t)
+}
@@ -0,0 +1,62 @@
+package database
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestReplaceVideoThumbnail(t *testing.T) {
+	c := newTestClient(t)
+	video := createTestVideo(t, c, CreateVideoParams{Title: "video", UserID: createTestUser(t, c).ID})
+
+	oldURL, oldThumbnails, err := c.ReplaceVideoThumbnail(video.ID, "/assets/thumbnails/a.jpg", ThumbnailSet{"160": "/assets/thumbnails/a-160.jpg"}, "image/jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if oldURL != nil || oldThumbnails != nil {
+		t.Errorf("first thumbnail replaced %v, %v", oldURL, oldThumbnails)
+	}
+
+	oldURL, oldThumbnails, err = c.ReplaceVideoThumbnail(video.ID, "/assets/thumbnails/b.jpg", nil, "image/jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if oldURL == nil || *oldURL != "/assets/thumbnails/a.jpg" || oldThumbnails["160"] != "/assets/thumbnails/a-160.jpg" {
+		t.Errorf("replaced %v, %v, want the first thumbnail", oldURL, oldThumbnails)
+	}
+	got, err := c.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ThumbnailURL == nil || *got.ThumbnailURL != "/assets/thumbnails/b.jpg" || got.Thumbnails != nil {
+		t.Errorf("thumbnail = %v, %v, want the second one", got.ThumbnailURL, got.Thumbnails)
+	}
+
+	if _, _, err := c.ReplaceVideoThumbnail(uuid.New(), "/assets/thumbnails/c.jpg", nil, "image/jpeg"); !errors.Is(err, ErrVideoNotFound) {
+		t.Errorf("replacing a missing video's thumbnail: error = %v, want ErrVideoNotFound", err)
+	}
+}
+
+func TestSetVideoThumbnailIfMissing(t *testing.T) {
+	c := newTestClient(t)
+	video := createTestVideo(t, c, CreateVideoParams{Title: "video", UserID: createTestUser(t, c).ID})
+
+	set, err := c.SetVideoThumbnailIfMissing(video.ID, "/assets/thumbnails/auto.jpg", nil, "image/jpeg")
+	if err != nil || !set {
+		t.Fatalf("setting the first thumbnail = %v, %v", set, err)
+	}
+	// An upload that landed first wins over the generated thumbnail
+	set, err = c.SetVideoThumbnailIfMissing(video.ID, "/assets/thumbnails/auto2.jpg", nil, "image/jpeg")
+	if err != nil || set {
+		t.Fatalf("setting a second thumbnail = %v, %v, want it skipped", set, err)
+	}
+	got, err := c.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ThumbnailURL == nil || *got.ThumbnailURL != "/assets/thumbnails/auto.jpg" {
+		t.Errorf("thumbnail = %v, want the first one", got.ThumbnailURL)
+	}
+}
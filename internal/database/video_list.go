@@ -0,0 +1,238 @@
+package database
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// VideoSort is a column GET /api/videos can be sorted by
+type VideoSort string
+
+const (
+	VideoSortCreatedAt VideoSort = "created_at"
+	VideoSortUpdatedAt VideoSort = "updated_at"
+	VideoSortTitle     VideoSort = "title"
+	VideoSortDuration  VideoSort = "duration"
+)
+
+const (
+	DefaultVideoListLimit = 20
+	MaxVideoListLimit     = 100
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// sortExpressions are the indexed expressions behind each sort.
+// Videos without a duration yet sort as -1 so keyset comparisons never see NULL.
+var sortExpressions = map[VideoSort]string{
+	VideoSortCreatedAt: "created_at",
+	VideoSortUpdatedAt: "updated_at",
+	VideoSortTitle:     "title",
+	VideoSortDuration:  "COALESCE(duration_seconds, -1)",
+}
+
+func (s VideoSort) Valid() bool {
+	_, ok := sortExpressions[s]
+	return ok
+}
+
+// ListVideosParams selects one page of a user's videos. The zero value of
+// every filter means "don't filter". ListPublicVideos ignores UserID.
+type ListVideosParams struct {
+	UserID     uuid.UUID
+	Sort       VideoSort
+	Descending bool
+	Limit      int
+	// Cursor is the NextCursor of the previous page
+	Cursor string
+
+	HasVideo      *bool
+	AspectRatio   string
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+}
+
+type VideoPage struct {
+	Videos []Video `json:"videos"`
+	// NextCursor is empty on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// videoCursor is the position after the last video of a page. It's tied to the
+// sort it was made for, and opaque to clients.
+type videoCursor struct {
+	Sort       VideoSort `json:"s"`
+	Descending bool      `json:"d"`
+	Value      any       `json:"v"`
+	ID         uuid.UUID `json:"id"`
+}
+
+// ListVideos returns a page of the user's videos using keyset pagination on
+// (sort column, id), so pages stay consistent while videos are added
+func (c Client) ListVideos(params ListVideosParams) (VideoPage, error) {
+	return c.listVideos(params, []string{"user_id = ?"}, []any{params.UserID})
+}
+
+// ListPublicVideos returns a page of every user's public videos that are ready to watch
+func (c Client) ListPublicVideos(params ListVideosParams) (VideoPage, error) {
+	return c.listVideos(params, []string{"visibility = ?", "status = ?"}, []any{VideoVisibilityPublic, VideoStatusReady})
+}
+
+// listVideos pages through the videos matching where, with its args, and the
+// filters in params. Trashed videos are never listed.
+func (c Client) listVideos(params ListVideosParams, where []string, args []any) (VideoPage, error) {
+	if params.Sort == "" {
+		params.Sort = VideoSortCreatedAt
+	}
+	sortExpr, ok := sortExpressions[params.Sort]
+	if !ok {
+		return VideoPage{}, fmt.Errorf("unknown sort %q", params.Sort)
+	}
+	if params.Limit <= 0 {
+		params.Limit = DefaultVideoListLimit
+	}
+	params.Limit = min(params.Limit, MaxVideoListLimit)
+
+	where = append(where, "deleted_at IS NULL")
+	if params.HasVideo != nil {
+		if *params.HasVideo {
+			where = append(where, "video_url IS NOT NULL")
+		} else {
+			where = append(where, "video_url IS NULL")
+		}
+	}
+	if params.AspectRatio != "" {
+		where = append(where, "aspect_ratio = ?")
+		args = append(args, params.AspectRatio)
+	}
+	if params.CreatedAfter != nil {
+		where = append(where, "created_at >= ?")
+		args = append(args, c.dialect.timeParam(*params.CreatedAfter))
+	}
+	if params.CreatedBefore != nil {
+		where = append(where, "created_at < ?")
+		args = append(args, c.dialect.timeParam(*params.CreatedBefore))
+	}
+
+	direction, comparison := "ASC", ">"
+	if params.Descending {
+		direction, comparison = "DESC", "<"
+	}
+	if params.Cursor != "" {
+		value, id, err := c.decodeVideoCursor(params.Cursor, params.Sort, params.Descending)
+		if err != nil {
+			return VideoPage{}, err
+		}
+		where = append(where, fmt.Sprintf("(%s, id) %s (?, ?)", sortExpr, comparison))
+		args = append(args, value, id)
+	}
+
+	// One extra row tells us whether there's another page
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE ` + strings.Join(where, " AND ") + `
+	ORDER BY ` + sortExpr + ` ` + direction + `, id ` + direction + `
+	LIMIT ?
+	`
+	args = append(args, params.Limit+1)
+
+	rows, err := c.query(query, args...)
+	if err != nil {
+		return VideoPage{}, err
+	}
+	defer rows.Close()
+
+	page := VideoPage{Videos: []Video{}}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return VideoPage{}, err
+		}
+		page.Videos = append(page.Videos, video)
+	}
+	if err := rows.Err(); err != nil {
+		return VideoPage{}, err
+	}
+
+	if len(page.Videos) > params.Limit {
+		page.Videos = page.Videos[:params.Limit]
+		last := page.Videos[len(page.Videos)-1]
+		page.NextCursor, err = encodeVideoCursor(last, params.Sort, params.Descending)
+		if err != nil {
+			return VideoPage{}, err
+		}
+	}
+	return page, nil
+}
+
+func encodeVideoCursor(last Video, sort VideoSort, descending bool) (string, error) {
+	cursor := videoCursor{Sort: sort, Descending: descending, ID: last.ID}
+	switch sort {
+	case VideoSortCreatedAt:
+		cursor.Value = last.CreatedAt.UTC().Format(time.RFC3339Nano)
+	case VideoSortUpdatedAt:
+		cursor.Value = last.UpdatedAt.UTC().Format(time.RFC3339Nano)
+	case VideoSortTitle:
+		cursor.Value = last.Title
+	case VideoSortDuration:
+		duration := -1.0
+		if last.DurationSeconds != nil {
+			duration = *last.DurationSeconds
+		}
+		cursor.Value = duration
+	}
+	dat, err := json.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(dat), nil
+}
+
+// decodeVideoCursor returns the sort value and id to continue after,
+// rejecting cursors made for a different sort
+func (c Client) decodeVideoCursor(encoded string, sort VideoSort, descending bool) (any, uuid.UUID, error) {
+	dat, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, uuid.Nil, ErrInvalidCursor
+	}
+	var cursor videoCursor
+	if err := json.Unmarshal(dat, &cursor); err != nil {
+		return nil, uuid.Nil, ErrInvalidCursor
+	}
+	if cursor.Sort != sort || cursor.Descending != descending {
+		return nil, uuid.Nil, fmt.Errorf("%w: it was made for a different sort", ErrInvalidCursor)
+	}
+
+	switch sort {
+	case VideoSortCreatedAt, VideoSortUpdatedAt:
+		s, ok := cursor.Value.(string)
+		if !ok {
+			return nil, uuid.Nil, ErrInvalidCursor
+		}
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return nil, uuid.Nil, ErrInvalidCursor
+		}
+		return c.dialect.timeParam(t), cursor.ID, nil
+	case VideoSortTitle:
+		s, ok := cursor.Value.(string)
+		if !ok {
+			return nil, uuid.Nil, ErrInvalidCursor
+		}
+		return s, cursor.ID, nil
+	case VideoSortDuration:
+		f, ok := cursor.Value.(float64)
+		if !ok {
+			return nil, uuid.Nil, ErrInvalidCursor
+		}
+		return f, cursor.ID, nil
+	}
+	return nil, uuid.Nil, ErrInvalidCursor
+}
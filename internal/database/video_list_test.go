@@ -0,0 +1,216 @@
+package database
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestVideoCursorRoundTrip(t *testing.T) {
+	c := newTestClient(t)
+	duration := 12.5
+	last := Video{
+		ID:        uuid.New(),
+		CreatedAt: time.Date(2024, 3, 1, 12, 30, 5, 250_000_000, time.UTC),
+		UpdatedAt: time.Date(2024, 3, 2, 8, 0, 0, 0, time.UTC),
+		MediaMetadata: MediaMetadata{
+			DurationSeconds: &duration,
+		},
+		CreateVideoParams: CreateVideoParams{Title: "Boots & cats"},
+	}
+
+	tests := []struct {
+		sort VideoSort
+		want any
+	}{
+		{VideoSortCreatedAt, "2024-03-01 12:30:05.250"},
+		{VideoSortUpdatedAt, "2024-03-02 08:00:00"},
+		{VideoSortTitle, "Boots & cats"},
+		{VideoSortDuration, 12.5},
+	}
+	for _, tt := range tests {
+		for _, descending := range []bool{false, true} {
+			cursor, err := encodeVideoCursor(last, tt.sort, descending)
+			if err != nil {
+				t.Fatalf("encoding a %s cursor: %v", tt.sort, err)
+			}
+			value, id, err := c.decodeVideoCursor(cursor, tt.sort, descending)
+			if err != nil {
+				t.Fatalf("decoding a %s cursor: %v", tt.sort, err)
+			}
+			if value != tt.want || id != last.ID {
+				t.Errorf("%s cursor decoded to %v, %s, want %v, %s", tt.sort, value, id, tt.want, last.ID)
+			}
+		}
+	}
+
+	// Videos without a duration sort as -1
+	last.DurationSeconds = nil
+	cursor, err := encodeVideoCursor(last, VideoSortDuration, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, _, err := c.decodeVideoCursor(cursor, VideoSortDuration, true); err != nil || value != -1.0 {
+		t.Errorf("cursor without a duration decoded to %v, %v, want -1", value, err)
+	}
+}
+
+func TestDecodeVideoCursorRejects(t *testing.T) {
+	c := newTestClient(t)
+	last := Video{ID: uuid.New(), CreateVideoParams: CreateVideoParams{Title: "a"}}
+	byTitle, err := encodeVideoCursor(last, VideoSortTitle, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encode := func(json string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(json))
+	}
+
+	tests := []struct {
+		name       string
+		cursor     string
+		sort       VideoSort
+		descending bool
+	}{
+		{"another sort", byTitle, VideoSortCreatedAt, false},
+		{"another direction", byTitle, VideoSortTitle, true},
+		{"not base64", "not a cursor!", VideoSortTitle, false},
+		{"not JSON", encode("{"), VideoSortTitle, false},
+		{"number for a title", encode(`{"s":"title","d":false,"v":3,"id":"` + last.ID.String() + `"}`), VideoSortTitle, false},
+		{"bad time", encode(`{"s":"created_at","d":true,"v":"yesterday","id":"` + last.ID.String() + `"}`), VideoSortCreatedAt, true},
+		{"string for a duration", encode(`{"s":"duration","d":true,"v":"1","id":"` + last.ID.String() + `"}`), VideoSortDuration, true},
+	}
+	for _, tt := range tests {
+		if _, _, err := c.decodeVideoCursor(tt.cursor, tt.sort, tt.descending); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("%s: error = %v, want ErrInvalidCursor", tt.name, err)
+		}
+	}
+}
+
+func TestListVideosPagesThroughEveryVideo(t *testing.T) {
+	c := newTestClient(t)
+	user := createTestUser(t, c)
+	other := createTestUser(t, c)
+	otherUser := func() CreateVideoParams {
+		return CreateVideoParams{Title: "not mine", UserID: other.ID}
+	}
+	createTestVideo(t, c, otherUser())
+
+	// Repeated titles and missing durations make the id tie-break matter
+	var ids []uuid.UUID
+	for i, title := range []string{"b", "a", "c", "a", "b", "d", "a"} {
+		video := createTestVideo(t, c, CreateVideoParams{Title: title, UserID: user.ID})
+		if i%2 == 0 {
+			duration := float64(i % 3)
+			if err := c.UpdateVideoMetadata(video.ID, MediaMetadata{DurationSeconds: &duration}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		ids = append(ids, video.ID)
+	}
+	trashed := createTestVideo(t, c, CreateVideoParams{Title: "a", UserID: user.ID})
+	if err := c.TrashVideo(trashed.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, sort := range []VideoSort{VideoSortCreatedAt, VideoSortUpdatedAt, VideoSortTitle, VideoSortDuration} {
+		for _, descending := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s descending=%v", sort, descending), func(t *testing.T) {
+				params := ListVideosParams{UserID: user.ID, Sort: sort, Descending: descending}
+				all, err := c.ListVideos(params)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if all.NextCursor != "" {
+					t.Fatalf("one page of everything has a next cursor")
+				}
+				want := videoIDs(all.Videos)
+				if !sameIDs(want, ids) {
+					t.Fatalf("listed %v, want every video of the user except the trashed one: %v", want, ids)
+				}
+
+				var got []uuid.UUID
+				params.Limit = 3
+				for pages := 0; ; pages++ {
+					if pages > len(ids) {
+						t.Fatalf("still paging after %d pages", pages)
+					}
+					page, err := c.ListVideos(params)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if len(page.Videos) > params.Limit {
+						t.Fatalf("page has %d videos, limit is %d", len(page.Videos), params.Limit)
+					}
+					got = append(got, videoIDs(page.Videos)...)
+					if page.NextCursor == "" {
+						break
+					}
+					params.Cursor = page.NextCursor
+				}
+				if !slices.Equal(got, want) {
+					t.Errorf("paged through %v, want %v", got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestListVideosFilters(t *testing.T) {
+	c := newTestClient(t)
+	user := createTestUser(t, c)
+	withFile := createTestVideo(t, c, CreateVideoParams{Title: "uploaded", UserID: user.ID})
+	if err := c.SetVideoFiles(withFile.ID, "https://cdn.example.com/landscape/a.mp4", "video/mp4", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	landscape := "16:9"
+	if err := c.UpdateVideoMetadata(withFile.ID, MediaMetadata{AspectRatio: &landscape}); err != nil {
+		t.Fatal(err)
+	}
+	draft := createTestVideo(t, c, CreateVideoParams{Title: "draft", UserID: user.ID})
+
+	yes, no := true, false
+	future := time.Now().Add(time.Hour)
+	tests := []struct {
+		name   string
+		params ListVideosParams
+		want   []uuid.UUID
+	}{
+		{"has video", ListVideosParams{HasVideo: &yes}, []uuid.UUID{withFile.ID}},
+		{"has no video", ListVideosParams{HasVideo: &no}, []uuid.UUID{draft.ID}},
+		{"aspect ratio", ListVideosParams{AspectRatio: "16:9"}, []uuid.UUID{withFile.ID}},
+		{"created after", ListVideosParams{CreatedAfter: &future}, nil},
+		{"created before", ListVideosParams{CreatedBefore: &future}, []uuid.UUID{withFile.ID, draft.ID}},
+	}
+	for _, tt := range tests {
+		tt.params.UserID = user.ID
+		page, err := c.ListVideos(tt.params)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := videoIDs(page.Videos); !sameIDs(got, tt.want) {
+			t.Errorf("%s: listed %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func videoIDs(videos []Video) []uuid.UUID {
+	var ids []uuid.UUID
+	for _, video := range videos {
+		ids = append(ids, video.ID)
+	}
+	return ids
+}
+
+func sameIDs(a, b []uuid.UUID) bool {
+	compare := func(x, y uuid.UUID) int { return slices.Compare(x[:], y[:]) }
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.SortFunc(a, compare)
+	slices.SortFunc(b, compare)
+	return slices.Equal(a, b)
+}
@@ -0,0 +1,59 @@
+package database
+
+import "github.com/google/uuid"
+
+// MediaMetadata is what ffprobe found in the processed video file.
+// Every field is nil until the video has been processed.
+type MediaMetadata struct {
+	DurationSeconds *float64 `json:"duration_seconds"`
+	Width           *int     `json:"width"`
+	Height          *int     `json:"height"`
+	VideoCodec      *string  `json:"video_codec"`
+	AudioCodec      *string  `json:"audio_codec"`
+	// Bitrate is the overall bitrate in bits per second
+	Bitrate   *int64   `json:"bitrate"`
+	FrameRate *float64 `json:"frame_rate"`
+	// Rotation is the clockwise display rotation in degrees (0, 90, 180 or 270)
+	Rotation      *int   `json:"rotation"`
+	AudioChannels *int   `json:"audio_channels"`
+	FileSize      *int64 `json:"file_size"`
+	// AspectRatio is the bucket the display size falls in, like "16:9" or "other"
+	AspectRatio *string `json:"aspect_ratio"`
+}
+
+// UpdateVideoMetadata replaces the probed metadata of a video.
+func (c Client) UpdateVideoMetadata(id uuid.UUID, meta MediaMetadata) error {
+	query := `
+	UPDATE videos
+	SET
+		duration_seconds = ?,
+		width = ?,
+		height = ?,
+		video_codec = ?,
+		audio_codec = ?,
+		bitrate = ?,
+		frame_rate = ?,
+		rotation = ?,
+		audio_channels = ?,
+		file_size = ?,
+		aspect_ratio = ?,
+		updated_at = ` + c.dialect.now() + `
+	WHERE id = ?
+	`
+	_, err := c.exec(
+		query,
+		meta.DurationSeconds,
+		meta.Width,
+		meta.Height,
+		meta.VideoCodec,
+		meta.AudioCodec,
+		meta.Bitrate,
+		meta.FrameRate,
+		meta.Rotation,
+		meta.AudioChannels,
+		meta.FileSize,
+		meta.AspectRatio,
+		id,
+	)
+	return err
+}
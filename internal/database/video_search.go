@@ -0,0 +1,393 @@
+package database
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+
+	"github.com/google/uuid"
+)
+
+// searchMode is how SearchVideos matches, picked once the schema is up to date
+type searchMode int
+
+const (
+	// searchLike scans titles and descriptions with LIKE. It's what SQLite
+	// falls back to when the driver was built without the sqlite_fts5 tag.
+	searchLike searchMode = iota
+	// searchFTS5 queries the videos_fts table
+	searchFTS5
+	// searchPostgres uses Postgres' text search over postgresSearchDocument
+	searchPostgres
+)
+
+// SearchMode names how SearchVideos matches: "fts5", "postgres", or "like"
+// when the SQLite driver was built without FTS5
+func (c Client) SearchMode() string {
+	switch c.search {
+	case searchFTS5:
+		return "fts5"
+	case searchPostgres:
+		return "postgres"
+	}
+	return "like"
+}
+
+// postgresSearchDocument is the expression idx_videos_search indexes,
+// Postgres only uses the index when queries repeat it exactly
+const postgresSearchDocument = `to_tsvector('simple', title || ' ' || COALESCE(description, ''))`
+
+// Highlight markers are private use characters, so they survive HTML escaping
+// and can't be confused with markup in a title
+const (
+	highlightStart = "\ue000"
+	highlightEnd   = "\ue001"
+)
+
+// maxSearchTerms caps how many words of a query are used
+const maxSearchTerms = 10
+
+// VideoSearchResult is a video matching a search. The highlights are HTML
+// escaped text with the matching words wrapped in <mark>.
+type VideoSearchResult struct {
+	Video                Video   `json:"video"`
+	Rank                 float64 `json:"rank"`
+	TitleHighlight       string  `json:"title_highlight"`
+	DescriptionHighlight string  `json:"description_highlight"`
+}
+
+// prepareSearch picks the search mode. On SQLite with FTS5 it creates videos_fts
+// if it's missing and rebuilds it when it has drifted from the videos table,
+// which happens if the server ran for a while without FTS5.
+func (c *Client) prepareSearch() error {
+	if c.dialect == dialectPostgres {
+		c.search = searchPostgres
+		return nil
+	}
+
+	var fts5 bool
+	if err := c.queryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&fts5); err != nil {
+		return err
+	}
+	if !fts5 {
+		c.search = searchLike
+		return nil
+	}
+
+	_, err := c.exec(`
+	CREATE VIRTUAL TABLE IF NOT EXISTS videos_fts USING fts5(
+		title,
+		description,
+		video_id UNINDEXED,
+		user_id UNINDEXED,
+		tokenize = 'unicode61 remove_diacritics 2'
+	)`)
+	if err != nil {
+		return fmt.Errorf("couldn't create search index: %w", err)
+	}
+
+	var videos, indexed int
+	if err := c.queryRow(`SELECT COUNT(*) FROM videos`).Scan(&videos); err != nil {
+		return err
+	}
+	if err := c.queryRow(`SELECT COUNT(*) FROM videos_fts`).Scan(&indexed); err != nil {
+		return err
+	}
+	if videos != indexed {
+		if _, err := c.exec(`DELETE FROM videos_fts`); err != nil {
+			return err
+		}
+		_, err := c.exec(`
+		INSERT INTO videos_fts (title, description, video_id, user_id)
+		SELECT title, COALESCE(description, ''), id, user_id FROM videos
+		`)
+		if err != nil {
+			return fmt.Errorf("couldn't rebuild search index: %w", err)
+		}
+	}
+
+	c.search = searchFTS5
+	return nil
+}
+
+// indexVideo replaces the video's row in videos_fts. The other modes search
+// the videos table directly and have nothing to keep in sync.
+func (c Client) indexVideo(id uuid.UUID, title, description string, userID uuid.UUID) error {
+	if c.search != searchFTS5 {
+		return nil
+	}
+	if err := c.unindexVideo(id); err != nil {
+		return err
+	}
+	_, err := c.exec(
+		`INSERT INTO videos_fts (title, description, video_id, user_id) VALUES (?, ?, ?, ?)`,
+		title, description, id, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("couldn't index video: %w", err)
+	}
+	return nil
+}
+
+func (c Client) unindexVideo(id uuid.UUID) error {
+	if c.search != searchFTS5 {
+		return nil
+	}
+	_, err := c.exec(`DELETE FROM videos_fts WHERE video_id = ?`, id)
+	return err
+}
+
+// SearchVideos returns the user's videos matching every word of q, best match first.
+// The last word also matches as a prefix so results show up while typing.
+func (c Client) SearchVideos(userID uuid.UUID, q string, limit int) ([]VideoSearchResult, error) {
+	if limit <= 0 {
+		limit = DefaultVideoListLimit
+	}
+	limit = min(limit, MaxVideoListLimit)
+
+	terms := strings.Fields(q)
+	if len(terms) > maxSearchTerms {
+		terms = terms[:maxSearchTerms]
+	}
+	if len(terms) == 0 {
+		return []VideoSearchResult{}, nil
+	}
+
+	switch c.search {
+	case searchFTS5:
+		return c.searchFTS5(userID, terms, limit)
+	case searchPostgres:
+		return c.searchPostgres(userID, terms, limit)
+	default:
+		return c.searchLike(userID, terms, limit)
+	}
+}
+
+func (c Client) searchFTS5(userID uuid.UUID, terms []string, limit int) ([]VideoSearchResult, error) {
+	// Quoting every word keeps FTS5's query syntax out of the user's hands
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+	}
+	quoted[len(quoted)-1] += "*"
+
+	// bm25 is lower for better matches, titles weigh more than descriptions
+	query := `
+	SELECT` + prefixColumns("v", videoColumns) + `,
+		-bm25(videos_fts, 5.0, 1.0),
+		highlight(videos_fts, 0, ?, ?),
+		snippet(videos_fts, 1, ?, ?, '…', 24)
+	FROM videos_fts
+	JOIN videos v ON v.id = videos_fts.video_id
+	WHERE videos_fts MATCH ? AND videos_fts.user_id = ? AND v.deleted_at IS NULL
+	ORDER BY bm25(videos_fts, 5.0, 1.0), v.created_at DESC
+	LIMIT ?
+	`
+	return c.scanSearchResults(query,
+		highlightStart, highlightEnd,
+		highlightStart, highlightEnd,
+		strings.Join(quoted, " "), userID, limit,
+	)
+}
+
+func (c Client) searchPostgres(userID uuid.UUID, terms []string, limit int) ([]VideoSearchResult, error) {
+	// Each word is a quoted lexeme so to_tsquery doesn't parse operators out of it
+	lexemes := make([]string, len(terms))
+	for i, term := range terms {
+		lexemes[i] = "'" + strings.ReplaceAll(term, "'", "''") + "'"
+	}
+	lexemes[len(lexemes)-1] += ":*"
+	markers := fmt.Sprintf("StartSel=%s, StopSel=%s", highlightStart, highlightEnd)
+
+	query := `
+	SELECT` + videoColumns + `,
+		ts_rank(` + postgresSearchDocument + `, q) AS rank,
+		ts_headline('simple', title, q, ?),
+		ts_headline('simple', COALESCE(description, ''), q, ?)
+	FROM videos, to_tsquery('simple', ?) q
+	WHERE user_id = ? AND deleted_at IS NULL AND ` + postgresSearchDocument + ` @@ q
+	ORDER BY rank DESC, created_at DESC
+	LIMIT ?
+	`
+	return c.scanSearchResults(query,
+		markers+", HighlightAll=true",
+		markers+", MaxWords=24, MinWords=8",
+		strings.Join(lexemes, " & "), userID, limit,
+	)
+}
+
+func (c Client) searchLike(userID uuid.UUID, terms []string, limit int) ([]VideoSearchResult, error) {
+	where := []string{"user_id = ?", "deleted_at IS NULL"}
+	whereArgs := []any{userID}
+	score := make([]string, 0, len(terms))
+	var scoreArgs []any
+	for _, term := range terms {
+		pattern := "%" + escapeLike(term) + "%"
+		where = append(where, `(title LIKE ? ESCAPE '\' OR COALESCE(description, '') LIKE ? ESCAPE '\')`)
+		whereArgs = append(whereArgs, pattern, pattern)
+		// A word in the title counts for more than one in the description
+		score = append(score, `(CASE WHEN title LIKE ? ESCAPE '\' THEN 5 ELSE 1 END)`)
+		scoreArgs = append(scoreArgs, pattern)
+	}
+
+	query := `
+	SELECT` + videoColumns + `,
+		` + strings.Join(score, " + ") + ` AS score,
+		'', ''
+	FROM videos
+	WHERE ` + strings.Join(where, " AND ") + `
+	ORDER BY score DESC, created_at DESC
+	LIMIT ?
+	`
+	args := append(scoreArgs, whereArgs...)
+	args = append(args, limit)
+
+	results, err := c.scanSearchResults(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	for i := range results {
+		results[i].TitleHighlight = formatHighlight(highlightTerms(results[i].Video.Title, terms, 0))
+		results[i].DescriptionHighlight = formatHighlight(highlightTerms(results[i].Video.Description, terms, 24))
+	}
+	return results, nil
+}
+
+// scanSearchResults runs a query selecting videoColumns followed by the rank
+// and the raw title and description highlights
+func (c Client) scanSearchResults(query string, args ...any) ([]VideoSearchResult, error) {
+	rows, err := c.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []VideoSearchResult{}
+	for rows.Next() {
+		var result VideoSearchResult
+		var title, description string
+		video, err := scanVideo(extraColumns{rows, []any{&result.Rank, &title, &description}})
+		if err != nil {
+			return nil, err
+		}
+		result.Video = video
+		result.TitleHighlight = formatHighlight(title)
+		result.DescriptionHighlight = formatHighlight(description)
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+// extraColumns lets scanVideo read rows that select more than videoColumns
+type extraColumns struct {
+	row   rowScanner
+	extra []any
+}
+
+func (e extraColumns) Scan(dest ...any) error {
+	return e.row.Scan(append(dest, e.extra...)...)
+}
+
+// prefixColumns qualifies every column of a column list with a table alias
+func prefixColumns(alias, columns string) string {
+	names := strings.Split(columns, ",")
+	for i, name := range names {
+		names[i] = " " + alias + "." + strings.TrimSpace(name)
+	}
+	return strings.Join(names, ",")
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// formatHighlight HTML escapes text marked up with the highlight markers
+// and turns the markers into <mark> tags
+func formatHighlight(s string) string {
+	s = html.EscapeString(s)
+	return strings.NewReplacer(highlightStart, "<mark>", highlightEnd, "</mark>").Replace(s)
+}
+
+// highlightTerms marks every case insensitive occurrence of the terms in text.
+// With a window above zero it cuts the text down to that many words around
+// the first match, like FTS5's snippet().
+func highlightTerms(text string, terms []string, window int) string {
+	runes := []rune(text)
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+
+	marked := make([]bool, len(runes))
+	first := -1
+	for _, term := range terms {
+		needle := []rune(strings.ToLower(term))
+		if len(needle) == 0 {
+			continue
+		}
+		for i := 0; i+len(needle) <= len(lower); i++ {
+			if string(lower[i:i+len(needle)]) != string(needle) {
+				continue
+			}
+			for j := i; j < i+len(needle); j++ {
+				marked[j] = true
+			}
+			if first == -1 || i < first {
+				first = i
+			}
+		}
+	}
+
+	start, end := 0, len(runes)
+	if window > 0 {
+		start, end = wordWindow(runes, max(first, 0), window)
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	for i := start; i < end; i++ {
+		if marked[i] && (i == start || !marked[i-1]) {
+			b.WriteString(highlightStart)
+		}
+		b.WriteRune(runes[i])
+		if marked[i] && (i == end-1 || !marked[i+1]) {
+			b.WriteString(highlightEnd)
+		}
+	}
+	if end < len(runes) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+// wordWindow returns the range of runes covering about count words,
+// starting a few words before the one containing rune offset at
+func wordWindow(runes []rune, at, count int) (int, int) {
+	var starts []int
+	current := 0
+	for i, r := range runes {
+		if !unicode.IsSpace(r) && (i == 0 || unicode.IsSpace(runes[i-1])) {
+			starts = append(starts, i)
+		}
+		if i <= at && len(starts) > 0 {
+			current = len(starts) - 1
+		}
+	}
+	if len(starts) == 0 {
+		return 0, len(runes)
+	}
+
+	first := max(0, current-count/4)
+	last := first + count
+	start, end := starts[first], len(runes)
+	if last < len(starts) {
+		end = starts[last]
+		for end > start && unicode.IsSpace(runes[end-1]) {
+			end--
+		}
+	}
+	return start, end
+}
@@ -0,0 +1,99 @@
+package database
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestSearchVideos(t *testing.T) {
+	c := newTestClient(t)
+	user := createTestUser(t, c)
+	inTitle := createTestVideo(t, c, CreateVideoParams{Title: "Pasta <script>alert(1)</script>", UserID: user.ID})
+	inDescription := createTestVideo(t, c, CreateVideoParams{Title: "Dinner", Description: "Boiling pasta for a crowd", UserID: user.ID})
+	percent := createTestVideo(t, c, CreateVideoParams{Title: "100% juice", UserID: user.ID})
+	createTestVideo(t, c, CreateVideoParams{Title: "Pasta by someone else", UserID: createTestUser(t, c).ID})
+	trashed := createTestVideo(t, c, CreateVideoParams{Title: "Trashed pasta", UserID: user.ID})
+	if err := c.TrashVideo(trashed.ID); err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("search mode %s", c.SearchMode())
+
+	// The last word matches as a prefix
+	results, err := c.SearchVideos(user.ID, "pas", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Video.ID != inTitle.ID || results[1].Video.ID != inDescription.ID {
+		t.Fatalf("searching pas = %v, want the title match before the description match", searchResultTitles(results))
+	}
+	if highlight := results[0].TitleHighlight; !strings.Contains(highlight, "<mark>Pas") || strings.Contains(highlight, "<script>") {
+		t.Errorf("title highlight = %q, want the match marked and the title escaped", highlight)
+	}
+	if highlight := results[1].DescriptionHighlight; !strings.Contains(highlight, "<mark>pas") {
+		t.Errorf("description highlight = %q, want the match marked", highlight)
+	}
+
+	tests := []struct {
+		q    string
+		want []uuid.UUID
+	}{
+		{"boiling pasta", []uuid.UUID{inDescription.ID}},
+		{"pasta juice", nil},
+		{"100%", []uuid.UUID{percent.ID}},
+		{`title:pasta OR "`, nil},
+		{"   ", nil},
+	}
+	for _, tt := range tests {
+		results, err := c.SearchVideos(user.ID, tt.q, 0)
+		if err != nil {
+			t.Errorf("searching %q: %v", tt.q, err)
+			continue
+		}
+		if len(results) != len(tt.want) {
+			t.Errorf("searching %q = %v, want %d results", tt.q, searchResultTitles(results), len(tt.want))
+			continue
+		}
+		for i, id := range tt.want {
+			if results[i].Video.ID != id {
+				t.Errorf("searching %q = %v", tt.q, searchResultTitles(results))
+			}
+		}
+	}
+}
+
+func searchResultTitles(results []VideoSearchResult) []string {
+	titles := make([]string, len(results))
+	for i, result := range results {
+		titles[i] = result.Video.Title
+	}
+	return titles
+}
+
+func TestHighlightTerms(t *testing.T) {
+	tests := []struct {
+		text   string
+		terms  []string
+		window int
+		want   string
+	}{
+		{"Cooking Pasta", []string{"pasta"}, 0, "Cooking <mark>Pasta</mark>"},
+		{"pastapasta", []string{"pasta"}, 0, "<mark>pastapasta</mark>"},
+		{"a <b> tag", []string{"b"}, 0, "a &lt;<mark>b</mark>&gt; tag"},
+		{"Crème brûlée", []string{"BRÛLÉE"}, 0, "Crème <mark>brûlée</mark>"},
+		{"no match", []string{"pasta"}, 0, "no match"},
+		{"one two three four five six seven eight nine ten", []string{"six"}, 4, "…five <mark>six</mark> seven eight…"},
+	}
+	for _, tt := range tests {
+		if got := formatHighlight(highlightTerms(tt.text, tt.terms, tt.window)); got != tt.want {
+			t.Errorf("highlighting %v in %q = %q, want %q", tt.terms, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	if got := escapeLike(`100%_\`); got != `100\%\_\\` {
+		t.Errorf("escapeLike = %q", got)
+	}
+}
@@ -0,0 +1,87 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/google/uuid"
+)
+
+// VideoStatus tracks where a video is in its upload and processing lifecycle
+type VideoStatus string
+
+const (
+	// VideoStatusDraft is a video with metadata but no file yet
+	VideoStatusDraft VideoStatus = "draft"
+	// VideoStatusUploading is a resumable upload in progress
+	VideoStatusUploading VideoStatus = "uploading"
+	// VideoStatusProcessing is an uploaded file waiting for or going through ffmpeg
+	VideoStatusProcessing VideoStatus = "processing"
+	// VideoStatusReady is a processed video with a playable VideoURL
+	VideoStatusReady VideoStatus = "ready"
+	// VideoStatusFailed is a video whose processing gave up, see StatusError
+	VideoStatusFailed VideoStatus = "failed"
+)
+
+var ErrInvalidStatusTransition = errors.New("invalid video status transition")
+
+// videoStatusTransitions lists the statuses each status may move to.
+// A new file can be uploaded over a ready or failed video, a cancelled
+// upload goes back to whatever the video was before (draft or ready),
+// and an abandoned resumable upload can be replaced by a new one.
+var videoStatusTransitions = map[VideoStatus][]VideoStatus{
+	VideoStatusDraft:      {VideoStatusUploading, VideoStatusProcessing},
+	VideoStatusUploading:  {VideoStatusUploading, VideoStatusProcessing, VideoStatusDraft, VideoStatusReady, VideoStatusFailed},
+	VideoStatusProcessing: {VideoStatusReady, VideoStatusFailed},
+	VideoStatusReady:      {VideoStatusUploading, VideoStatusProcessing},
+	VideoStatusFailed:     {VideoStatusUploading, VideoStatusProcessing},
+}
+
+func (s VideoStatus) CanTransitionTo(next VideoStatus) bool {
+	return slices.Contains(videoStatusTransitions[s], next)
+}
+
+// UpdateVideoStatus moves a video to a new status, returning ErrInvalidStatusTransition
+// if the state machine doesn't allow it. statusError is only kept for VideoStatusFailed.
+func (c Client) UpdateVideoStatus(id uuid.UUID, status VideoStatus, statusError string) error {
+	var current VideoStatus
+	err := c.queryRow(`SELECT status FROM videos WHERE id = ?`, id).Scan(&current)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("video %s not found", id)
+		}
+		return err
+	}
+	if !current.CanTransitionTo(status) {
+		return fmt.Errorf("%w: %s -> %s", ErrInvalidStatusTransition, current, status)
+	}
+
+	var errorValue *string
+	if status == VideoStatusFailed && statusError != "" {
+		errorValue = &statusError
+	}
+
+	// Only update if nobody changed the status since we read it
+	query := `
+	UPDATE videos
+	SET
+		status = ?,
+		status_error = ?,
+		updated_at = ` + c.dialect.now() + `
+	WHERE id = ? AND status = ?
+	`
+	res, err := c.exec(query, status, errorValue, id, current)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: status of video %s changed concurrently", ErrInvalidStatusTransition, id)
+	}
+	return nil
+}
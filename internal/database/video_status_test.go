@@ -0,0 +1,66 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestVideoStatusCanTransitionTo(t *testing.T) {
+	tests := []struct {
+		from, to VideoStatus
+		want     bool
+	}{
+		{VideoStatusDraft, VideoStatusUploading, true},
+		{VideoStatusDraft, VideoStatusProcessing, true},
+		{VideoStatusDraft, VideoStatusReady, false},
+		{VideoStatusUploading, VideoStatusUploading, true},
+		{VideoStatusUploading, VideoStatusDraft, true},
+		{VideoStatusUploading, VideoStatusReady, true},
+		{VideoStatusProcessing, VideoStatusReady, true},
+		{VideoStatusProcessing, VideoStatusFailed, true},
+		{VideoStatusProcessing, VideoStatusUploading, false},
+		{VideoStatusProcessing, VideoStatusDraft, false},
+		{VideoStatusReady, VideoStatusProcessing, true},
+		{VideoStatusReady, VideoStatusDraft, false},
+		{VideoStatusFailed, VideoStatusUploading, true},
+		{VideoStatusFailed, VideoStatusReady, false},
+		{VideoStatus("bogus"), VideoStatusDraft, false},
+	}
+	for _, tt := range tests {
+		if got := tt.from.CanTransitionTo(tt.to); got != tt.want {
+			t.Errorf("%s -> %s allowed = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestUpdateVideoStatus(t *testing.T) {
+	c := newTestClient(t)
+	user := createTestUser(t, c)
+	video := createTestVideo(t, c, CreateVideoParams{Title: "clip", UserID: user.ID})
+
+	if err := c.UpdateVideoStatus(video.ID, VideoStatusReady, ""); !errors.Is(err, ErrInvalidStatusTransition) {
+		t.Fatalf("draft -> ready error = %v, want ErrInvalidStatusTransition", err)
+	}
+
+	if err := c.UpdateVideoStatus(video.ID, VideoStatusProcessing, "ignored"); err != nil {
+		t.Fatalf("draft -> processing: %v", err)
+	}
+	got, err := c.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != VideoStatusProcessing || got.StatusError != nil {
+		t.Fatalf("status = %s, error = %v, want processing without an error", got.Status, got.StatusError)
+	}
+
+	if err := c.UpdateVideoStatus(video.ID, VideoStatusFailed, "ffmpeg exited 1"); err != nil {
+		t.Fatalf("processing -> failed: %v", err)
+	}
+	got, err = c.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != VideoStatusFailed || got.StatusError == nil || *got.StatusError != "ffmpeg exited 1" {
+		t.Fatalf("status = %s, error = %v, want failed with the ffmpeg error", got.Status, got.StatusError)
+	}
+}
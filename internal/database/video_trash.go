@@ -0,0 +1,124 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TrashVideo moves a video to the trash. It's hidden from GetVideo, the list and
+// search until it's restored or purged for good with DeleteVideo.
+func (c Client) TrashVideo(id uuid.UUID) error {
+	query := `
+	UPDATE videos
+	SET
+		deleted_at = ` + c.dialect.now() + `,
+		updated_at = ` + c.dialect.now() + `
+	WHERE id = ? AND deleted_at IS NULL
+	`
+	_, err := c.exec(query, id)
+	return err
+}
+
+// RestoreVideo takes a video back out of the trash
+func (c Client) RestoreVideo(id uuid.UUID) error {
+	query := `
+	UPDATE videos
+	SET
+		deleted_at = NULL,
+		updated_at = ` + c.dialect.now() + `
+	WHERE id = ?
+	`
+	_, err := c.exec(query, id)
+	return err
+}
+
+// GetVideoWithTrashed returns the video even if it's in the trash, for work
+// that has to carry on for a video that may still be restored
+func (c Client) GetVideoWithTrashed(id uuid.UUID) (Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE id = ?
+	`
+
+	video, err := scanVideo(c.queryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Video{}, nil
+		}
+		return Video{}, err
+	}
+	return video, nil
+}
+
+// GetTrashedVideos returns the user's trashed videos, most recently deleted first
+func (c Client) GetTrashedVideos(userID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE user_id = ? AND deleted_at IS NOT NULL
+	ORDER BY deleted_at DESC
+	`
+	return c.queryVideos(query, userID)
+}
+
+// GetVideosTrashedBefore returns every user's videos that went to the trash before t
+func (c Client) GetVideosTrashedBefore(t time.Time) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE deleted_at < ?
+	ORDER BY deleted_at
+	`
+	return c.queryVideos(query, c.dialect.timeParam(t))
+}
+
+// PurgeTrashedVideo deletes the video for good if it's still in the trash
+// and went there before t. It reports whether it did, a video restored in
+// the meantime is left alone.
+func (c Client) PurgeTrashedVideo(id uuid.UUID, t time.Time) (bool, error) {
+	before := c.dialect.timeParam(t)
+	// Postgres enforces the foreign key from video_uploads
+	_, err := c.exec(`
+	DELETE FROM video_uploads
+	WHERE video_id IN (
+		SELECT id FROM videos WHERE id = ? AND deleted_at IS NOT NULL AND deleted_at < ?
+	)`, id, before)
+	if err != nil {
+		return false, err
+	}
+
+	res, err := c.exec(`
+	DELETE FROM videos
+	WHERE id = ? AND deleted_at IS NOT NULL AND deleted_at < ?
+	`, id, before)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	return true, c.unindexVideo(id)
+}
+
+func (c Client) queryVideos(query string, args ...any) ([]Video, error) {
+	rows, err := c.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+	return videos, rows.Err()
+}
@@ -0,0 +1,100 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestTrashAndRestoreVideo(t *testing.T) {
+	c := newTestClient(t)
+	user := createTestUser(t, c)
+	video := createTestVideo(t, c, CreateVideoParams{Title: "video", UserID: user.ID})
+
+	if err := c.TrashVideo(video.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := c.GetVideo(video.ID); err != nil || got.ID != uuid.Nil {
+		t.Errorf("GetVideo on a trashed video = %v, %v, want nothing", got.ID, err)
+	}
+	if got, err := c.GetVideoWithTrashed(video.ID); err != nil || got.ID != video.ID || got.DeletedAt == nil {
+		t.Errorf("GetVideoWithTrashed = %v, %v, want the trashed video", got.ID, err)
+	}
+	trashed, err := c.GetTrashedVideos(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trashed) != 1 || trashed[0].ID != video.ID {
+		t.Errorf("GetTrashedVideos = %d videos, want the trashed one", len(trashed))
+	}
+
+	if err := c.RestoreVideo(video.ID); err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != video.ID || got.DeletedAt != nil {
+		t.Errorf("after restoring: %v deleted at %v", got.ID, got.DeletedAt)
+	}
+}
+
+func TestPurgeTrashedVideo(t *testing.T) {
+	c := newTestClient(t)
+	user := createTestUser(t, c)
+	trashed := createTestVideo(t, c, CreateVideoParams{Title: "trashed", UserID: user.ID})
+	restored := createTestVideo(t, c, CreateVideoParams{Title: "restored", UserID: user.ID})
+	kept := createTestVideo(t, c, CreateVideoParams{Title: "kept", UserID: user.ID})
+	for _, id := range []uuid.UUID{trashed.ID, restored.ID} {
+		if err := c.TrashVideo(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before := time.Now().Add(time.Minute)
+
+	due, err := c.GetVideosTrashedBefore(before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 2 {
+		t.Errorf("GetVideosTrashedBefore = %d videos, want both trashed ones", len(due))
+	}
+	if due, err := c.GetVideosTrashedBefore(time.Now().Add(-time.Minute)); err != nil || len(due) != 0 {
+		t.Errorf("videos trashed over a minute ago = %d, %v, want none", len(due), err)
+	}
+
+	// Restored after the purge picked it up
+	if err := c.RestoreVideo(restored.ID); err != nil {
+		t.Fatal(err)
+	}
+	if purged, err := c.PurgeTrashedVideo(trashed.ID, time.Now().Add(-time.Minute)); err != nil || purged {
+		t.Errorf("purging a video trashed after the cutoff = %v, %v", purged, err)
+	}
+	for _, tt := range []struct {
+		id   uuid.UUID
+		want bool
+	}{
+		{trashed.ID, true},
+		{restored.ID, false},
+		{kept.ID, false},
+	} {
+		purged, err := c.PurgeTrashedVideo(tt.id, before)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if purged != tt.want {
+			t.Errorf("purging %v = %v, want %v", tt.id, purged, tt.want)
+		}
+	}
+
+	if got, err := c.GetVideoWithTrashed(trashed.ID); err != nil || got.ID != uuid.Nil {
+		t.Errorf("purged video is still there: %v, %v", got.ID, err)
+	}
+	for _, id := range []uuid.UUID{restored.ID, kept.ID} {
+		if got, err := c.GetVideo(id); err != nil || got.ID != id {
+			t.Errorf("video %v is gone: %v", id, err)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrUploadOffsetMismatch = errors.New("upload offset mismatch")
+
+// VideoUpload is the persisted state of a resumable (tus) upload.
+// The bytes themselves live in the staging file at FilePath.
+type VideoUpload struct {
+	ID          uuid.UUID  `json:"id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+	Offset      int64      `json:"upload_offset"`
+	CreateVideoUploadParams
+}
+
+type CreateVideoUploadParams struct {
+	VideoID   uuid.UUID `json:"video_id"`
+	UserID    uuid.UUID `json:"user_id"`
+	Length    int64     `json:"upload_length"`
+	MediaType string    `json:"media_type"`
+	FilePath  string    `json:"-"`
+}
+
+func (c Client) CreateVideoUpload(params CreateVideoUploadParams) (VideoUpload, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO video_uploads (
+		id,
+		created_at,
+		updated_at,
+		video_id,
+		user_id,
+		upload_length,
+		upload_offset,
+		media_type,
+		file_path
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, 0, ?, ?)
+	`
+	_, err := c.exec(
+		query,
+		id,
+		params.VideoID,
+		params.UserID,
+		params.Length,
+		params.MediaType,
+		params.FilePath,
+	)
+	if err != nil {
+		return VideoUpload{}, err
+	}
+
+	return c.GetVideoUpload(id)
+}
+
+// videoUploadColumns is the column list every upload query selects, in the order scanVideoUpload expects
+const videoUploadColumns = `
+		id,
+		created_at,
+		updated_at,
+		completed_at,
+		video_id,
+		user_id,
+		upload_length,
+		upload_offset,
+		media_type,
+		file_path`
+
+func scanVideoUpload(row rowScanner) (VideoUpload, error) {
+	var upload VideoUpload
+	err := row.Scan(
+		&upload.ID,
+		&upload.CreatedAt,
+		&upload.UpdatedAt,
+		&upload.CompletedAt,
+		&upload.VideoID,
+		&upload.UserID,
+		&upload.Length,
+		&upload.Offset,
+		&upload.MediaType,
+		&upload.FilePath,
+	)
+	return upload, err
+}
+
+func (c Client) GetVideoUpload(id uuid.UUID) (VideoUpload, error) {
+	query := `
+	SELECT` + videoUploadColumns + `
+	FROM video_uploads
+	WHERE id = ?
+	`
+
+	upload, err := scanVideoUpload(c.queryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return VideoUpload{}, nil
+		}
+		return VideoUpload{}, err
+	}
+
+	return upload, nil
+}
+
+// GetIncompleteVideoUploads returns the uploads of a video that haven't received every byte yet
+func (c Client) GetIncompleteVideoUploads(videoID uuid.UUID) ([]VideoUpload, error) {
+	query := `
+	SELECT` + videoUploadColumns + `
+	FROM video_uploads
+	WHERE video_id = ? AND completed_at IS NULL
+	`
+	return c.queryVideoUploads(query, videoID)
+}
+
+// GetVideoUploadsUpdatedBefore returns the uploads, complete or not, last written to before t
+func (c Client) GetVideoUploadsUpdatedBefore(t time.Time) ([]VideoUpload, error) {
+	query := `
+	SELECT` + videoUploadColumns + `
+	FROM video_uploads
+	WHERE updated_at < ?
+	`
+	return c.queryVideoUploads(query, c.dialect.timeParam(t))
+}
+
+// GetVideoUploadFilePaths returns the staging file of every upload
+func (c Client) GetVideoUploadFilePaths() ([]string, error) {
+	rows, err := c.query(`SELECT file_path FROM video_uploads`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	paths := []string{}
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			return nil, err
+		}
+		paths = append(paths, p)
+	}
+	return paths, rows.Err()
+}
+
+func (c Client) queryVideoUploads(query string, args ...any) ([]VideoUpload, error) {
+	rows, err := c.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	uploads := []VideoUpload{}
+	for rows.Next() {
+		upload, err := scanVideoUpload(rows)
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, upload)
+	}
+	return uploads, rows.Err()
+}
+
+// AdvanceVideoUpload moves the offset of an upload from `from` to `to`.
+// It returns ErrUploadOffsetMismatch if another request got there first.
+func (c Client) AdvanceVideoUpload(id uuid.UUID, from, to int64) error {
+	query := `
+	UPDATE video_uploads
+	SET
+		upload_offset = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND upload_offset = ?
+	`
+	res, err := c.exec(query, to, id, from)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUploadOffsetMismatch
+	}
+	return nil
+}
+
+func (c Client) CompleteVideoUpload(id uuid.UUID) error {
+	query := `
+	UPDATE video_uploads
+	SET
+		completed_at = CURRENT_TIMESTAMP,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.exec(query, id)
+	return err
+}
+
+func (c Client) DeleteVideoUpload(id uuid.UUID) error {
+	query := `
+	DELETE FROM video_uploads
+	WHERE id = ?
+	`
+	_, err := c.exec(query, id)
+	return err
+}
+
+// DeleteVideoUploadIfIdle deletes the upload if it still hasn't been written
+// to since before t, reporting whether it did
+func (c Client) DeleteVideoUploadIfIdle(id uuid.UUID, t time.Time) (bool, error) {
+	query := `
+	DELETE FROM video_uploads
+	WHERE id = ? AND updated_at < ?
+	`
+	res, err := c.exec(query, id, c.dialect.timeParam(t))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,48 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+// VideoVisibility is who can watch a video
+type VideoVisibility string
+
+const (
+	// VideoVisibilityPrivate videos can only be watched by their owner
+	VideoVisibilityPrivate VideoVisibility = "private"
+	// VideoVisibilityUnlisted videos can be watched by anyone who has the link
+	VideoVisibilityUnlisted VideoVisibility = "unlisted"
+	// VideoVisibilityPublic videos can be watched by anyone and are listed in the public feed
+	VideoVisibilityPublic VideoVisibility = "public"
+)
+
+func (v VideoVisibility) Valid() bool {
+	switch v {
+	case VideoVisibilityPrivate, VideoVisibilityUnlisted, VideoVisibilityPublic:
+		return true
+	}
+	return false
+}
+
+// GetVisibleVideo returns the video if viewerID may watch it: any video that
+// isn't private, and private videos only to their owner. Anonymous viewers
+// pass uuid.Nil. Like GetVideo, it returns the zero Video if there's nothing to see.
+func (c Client) GetVisibleVideo(id, viewerID uuid.UUID) (Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE id = ? AND deleted_at IS NULL AND (visibility <> ? OR user_id = ?)
+	`
+
+	video, err := scanVideo(c.queryRow(query, id, VideoVisibilityPrivate, viewerID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Video{}, nil
+		}
+		return Video{}, err
+	}
+	return video, nil
+}
@@ -0,0 +1,87 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestGetVisibleVideo(t *testing.T) {
+	c := newTestClient(t)
+	owner := createTestUser(t, c)
+	stranger := createTestUser(t, c)
+	videos := map[VideoVisibility]Video{}
+	for _, visibility := range []VideoVisibility{VideoVisibilityPrivate, VideoVisibilityUnlisted, VideoVisibilityPublic} {
+		videos[visibility] = createTestVideo(t, c, CreateVideoParams{Title: string(visibility), UserID: owner.ID, Visibility: visibility})
+	}
+
+	tests := []struct {
+		name       string
+		visibility VideoVisibility
+		viewerID   uuid.UUID
+		visible    bool
+	}{
+		{"owner, private", VideoVisibilityPrivate, owner.ID, true},
+		{"stranger, private", VideoVisibilityPrivate, stranger.ID, false},
+		{"anonymous, private", VideoVisibilityPrivate, uuid.Nil, false},
+		{"stranger, unlisted", VideoVisibilityUnlisted, stranger.ID, true},
+		{"anonymous, unlisted", VideoVisibilityUnlisted, uuid.Nil, true},
+		{"anonymous, public", VideoVisibilityPublic, uuid.Nil, true},
+	}
+	for _, tt := range tests {
+		video := videos[tt.visibility]
+		got, err := c.GetVisibleVideo(video.ID, tt.viewerID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if visible := got.ID == video.ID; visible != tt.visible {
+			t.Errorf("%s: visible = %v, want %v", tt.name, visible, tt.visible)
+		}
+	}
+
+	if err := c.TrashVideo(videos[VideoVisibilityPublic].ID); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := c.GetVisibleVideo(videos[VideoVisibilityPublic].ID, owner.ID); err != nil || got.ID != uuid.Nil {
+		t.Errorf("trashed video is visible: %v, %v", got.ID, err)
+	}
+}
+
+func TestCreateVideoDefaultsToPrivate(t *testing.T) {
+	c := newTestClient(t)
+	video := createTestVideo(t, c, CreateVideoParams{Title: "video", UserID: createTestUser(t, c).ID})
+	if video.Visibility != VideoVisibilityPrivate {
+		t.Errorf("visibility = %q, want private", video.Visibility)
+	}
+}
+
+func TestListPublicVideos(t *testing.T) {
+	c := newTestClient(t)
+	user := createTestUser(t, c)
+	ready := func(visibility VideoVisibility) Video {
+		t.Helper()
+		video := createTestVideo(t, c, CreateVideoParams{Title: string(visibility), UserID: user.ID, Visibility: visibility})
+		for _, status := range []VideoStatus{VideoStatusProcessing, VideoStatusReady} {
+			if err := c.UpdateVideoStatus(video.ID, status, ""); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return video
+	}
+	public := ready(VideoVisibilityPublic)
+	ready(VideoVisibilityUnlisted)
+	ready(VideoVisibilityPrivate)
+	createTestVideo(t, c, CreateVideoParams{Title: "still a draft", UserID: user.ID, Visibility: VideoVisibilityPublic})
+	trashed := ready(VideoVisibilityPublic)
+	if err := c.TrashVideo(trashed.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := c.ListPublicVideos(ListVideosParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Videos) != 1 || page.Videos[0].ID != public.ID {
+		t.Errorf("public feed = %v, want only the ready public video", videoIDs(page.Videos))
+	}
+}
@@ -9,11 +9,24 @@ import (
 )
 
 type Video struct {
-	ID           uuid.UUID `json:"id"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
-	ThumbnailURL *string   `json:"thumbnail_url"`
-	VideoURL     *string   `json:"video_url"`
+	ID           uuid.UUID   `json:"id"`
+	CreatedAt    time.Time   `json:"created_at"`
+	UpdatedAt    time.Time   `json:"updated_at"`
+	ThumbnailURL *string     `json:"thumbnail_url"`
+	VideoURL     *string     `json:"video_url"`
+	HLSURL       *string     `json:"hls_url"`
+	DASHURL      *string     `json:"dash_url"`
+	Status       VideoStatus `json:"status"`
+	StatusError  *string     `json:"status_error"`
+	// Thumbnails maps a width in pixels to the URL of the thumbnail resized to it
+	Thumbnails ThumbnailSet `json:"thumbnails"`
+	// ThumbnailMediaType and VideoMediaType are the types detected from the
+	// uploaded content, not the ones the client declared
+	ThumbnailMediaType *string `json:"thumbnail_media_type"`
+	VideoMediaType     *string `json:"video_media_type"`
+	// DeletedAt is when the video was moved to the trash, nil if it isn't there
+	DeletedAt *time.Time `json:"deleted_at"`
+	MediaMetadata
 	CreateVideoParams
 }
 
@@ -21,11 +34,12 @@ type CreateVideoParams struct {
 	Title       string    `json:"title"`
 	Description string    `json:"description"`
 	UserID      uuid.UUID `json:"user_id"`
+	// Visibility defaults to private
+	Visibility VideoVisibility `json:"visibility"`
 }
 
-func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
-	query := `
-	SELECT
+// videoColumns is the column list every video query selects, in the order scanVideo expects
+const videoColumns = `
 		id,
 		created_at,
 		updated_at,
@@ -33,13 +47,76 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 		description,
 		thumbnail_url,
 		video_url,
-		user_id
+		hls_url,
+		dash_url,
+		status,
+		status_error,
+		thumbnails,
+		thumbnail_media_type,
+		video_media_type,
+		duration_seconds,
+		width,
+		height,
+		video_codec,
+		audio_codec,
+		bitrate,
+		frame_rate,
+		rotation,
+		audio_channels,
+		file_size,
+		aspect_ratio,
+		deleted_at,
+		visibility,
+		user_id`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanVideo(row rowScanner) (Video, error) {
+	var video Video
+	err := row.Scan(
+		&video.ID,
+		&video.CreatedAt,
+		&video.UpdatedAt,
+		&video.Title,
+		&video.Description,
+		&video.ThumbnailURL,
+		&video.VideoURL,
+		&video.HLSURL,
+		&video.DASHURL,
+		&video.Status,
+		&video.StatusError,
+		&video.Thumbnails,
+		&video.ThumbnailMediaType,
+		&video.VideoMediaType,
+		&video.DurationSeconds,
+		&video.Width,
+		&video.Height,
+		&video.VideoCodec,
+		&video.AudioCodec,
+		&video.Bitrate,
+		&video.FrameRate,
+		&video.Rotation,
+		&video.AudioChannels,
+		&video.FileSize,
+		&video.AspectRatio,
+		&video.DeletedAt,
+		&video.Visibility,
+		&video.UserID,
+	)
+	return video, err
+}
+
+func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
 	FROM videos
-	WHERE user_id = ?
+	WHERE user_id = ? AND deleted_at IS NULL
 	ORDER BY created_at DESC
 	`
 
-	rows, err := c.db.Query(query, userID)
+	rows, err := c.query(query, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -47,17 +124,8 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 
 	videos := []Video{}
 	for rows.Next() {
-		var video Video
-		if err := rows.Scan(
-			&video.ID,
-			&video.CreatedAt,
-			&video.UpdatedAt,
-			&video.Title,
-			&video.Description,
-			&video.ThumbnailURL,
-			&video.VideoURL,
-			&video.UserID,
-		); err != nil {
+		video, err := scanVideo(rows)
+		if err != nil {
 			return nil, err
 		}
 		videos = append(videos, video)
@@ -66,6 +134,16 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 	return videos, nil
 }
 
+// GetAllVideos returns every user's videos including the trashed ones,
+// for housekeeping that needs to know everything the database references
+func (c Client) GetAllVideos() ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	`
+	return c.queryVideos(query)
+}
+
 func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 	id := uuid.New()
 	query := `
@@ -75,42 +153,34 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 		updated_at,
 		title,
 		description,
+		status,
+		visibility,
 		user_id
-	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+	) VALUES (?, ` + c.dialect.now() + `, ` + c.dialect.now() + `, ?, ?, ?, ?, ?)
 	`
-	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID)
+	if params.Visibility == "" {
+		params.Visibility = VideoVisibilityPrivate
+	}
+	_, err := c.exec(query, id, params.Title, params.Description, VideoStatusDraft, params.Visibility, params.UserID)
 	if err != nil {
 		return Video{}, err
 	}
+	if err := c.indexVideo(id, params.Title, params.Description, params.UserID); err != nil {
+		return Video{}, err
+	}
 
 	return c.GetVideo(id)
 }
 
+// GetVideo returns the video unless it's in the trash, see GetVideoWithTrashed
 func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 	query := `
-	SELECT
-		id,
-		created_at,
-		updated_at,
-		title,
-		description,
-		thumbnail_url,
-		video_url,
-		user_id
+	SELECT` + videoColumns + `
 	FROM videos
-	WHERE id = ?
+	WHERE id = ? AND deleted_at IS NULL
 	`
 
-	var video Video
-	err := c.db.QueryRow(query, id).Scan(
-		&video.ID,
-		&video.CreatedAt,
-		&video.UpdatedAt,
-		&video.Title,
-		&video.Description,
-		&video.ThumbnailURL,
-		&video.VideoURL,
-		&video.UserID)
+	video, err := scanVideo(c.queryRow(query, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return Video{}, nil
@@ -121,35 +191,151 @@ func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 	return video, nil
 }
 
-func (c Client) UpdateVideo(video Video) error {
+// SetVideoFiles records where the processed video and its HLS and DASH
+// renditions are stored. Only those columns are written, so edits made while
+// the video was processing aren't lost.
+func (c Client) SetVideoFiles(id uuid.UUID, videoURL, mediaType string, hlsURL, dashURL *string) error {
 	query := `
 	UPDATE videos
 	SET
-		title = ?,
-		description = ?,
-		thumbnail_url = ?,
 		video_url = ?,
-		user_id = ?
+		video_media_type = ?,
+		hls_url = ?,
+		dash_url = ?,
+		updated_at = ` + c.dialect.now() + `
 	WHERE id = ?
 	`
-
-	_, err := c.db.Exec(
-		query,
-		video.Title,
-		video.Description,
-		&video.ThumbnailURL,
-		&video.VideoURL,
-		video.UserID,
-		video.ID,
-	)
+	_, err := c.exec(query, videoURL, mediaType, hlsURL, dashURL, id)
 	return err
 }
 
+// ErrVideoNotFound means the video to update doesn't exist (anymore)
+var ErrVideoNotFound = errors.New("video not found")
+
+// ReplaceVideoThumbnail sets the thumbnail and returns the one it replaced, so
+// its files can be deleted. If another write changes the thumbnail in between,
+// it tries again against that one.
+func (c Client) ReplaceVideoThumbnail(id uuid.UUID, thumbnailURL string, thumbnails ThumbnailSet, mediaType string) (*string, ThumbnailSet, error) {
+	for {
+		var oldURL *string
+		var oldThumbnails ThumbnailSet
+		err := c.queryRow(`SELECT thumbnail_url, thumbnails FROM videos WHERE id = ?`, id).Scan(&oldURL, &oldThumbnails)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, ErrVideoNotFound
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// Every thumbnail gets new file names, so the URL identifies it
+		current := ""
+		if oldURL != nil {
+			current = *oldURL
+		}
+		query := `
+		UPDATE videos
+		SET thumbnail_url = ?, thumbnails = ?, thumbnail_media_type = ?, updated_at = ` + c.dialect.now() + `
+		WHERE id = ? AND COALESCE(thumbnail_url, '') = ?
+		`
+		res, err := c.exec(query, thumbnailURL, thumbnails, mediaType, id, current)
+		if err != nil {
+			return nil, nil, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return nil, nil, err
+		}
+		if n > 0 {
+			return oldURL, oldThumbnails, nil
+		}
+	}
+}
+
+// ErrVideoModified means the video changed since the version an update was based on
+var ErrVideoModified = errors.New("video was modified")
+
+// UpdateVideoDetailsParams are the user editable fields of a video. Nil fields are left alone.
+type UpdateVideoDetailsParams struct {
+	Title       *string
+	Description *string
+	Visibility  *VideoVisibility
+}
+
+// UpdateVideoDetails changes the title, description and visibility and bumps updated_at. If
+// ifUpdatedAt is set, the update only happens if the video is still at that
+// version, otherwise it returns ErrVideoModified.
+func (c Client) UpdateVideoDetails(id uuid.UUID, params UpdateVideoDetailsParams, ifUpdatedAt *time.Time) (Video, error) {
+	query := `
+	UPDATE videos
+	SET
+		title = COALESCE(?, title),
+		description = COALESCE(?, description),
+		visibility = COALESCE(?, visibility),
+		updated_at = ` + c.dialect.now() + `
+	WHERE id = ?
+	`
+	args := []any{params.Title, params.Description, params.Visibility, id}
+	if ifUpdatedAt != nil {
+		query += ` AND updated_at = ?`
+		args = append(args, c.dialect.timeParam(*ifUpdatedAt))
+	}
+
+	res, err := c.exec(query, args...)
+	if err != nil {
+		return Video{}, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return Video{}, err
+	}
+	if n == 0 {
+		return Video{}, ErrVideoModified
+	}
+
+	video, err := c.GetVideo(id)
+	if err != nil {
+		return Video{}, err
+	}
+	if err := c.indexVideo(video.ID, video.Title, video.Description, video.UserID); err != nil {
+		return Video{}, err
+	}
+	return video, nil
+}
+
+// DeleteVideo removes the video for good, TrashVideo is the recoverable delete
 func (c Client) DeleteVideo(id uuid.UUID) error {
+	// Postgres enforces the foreign key from video_uploads
+	_, err := c.exec(`DELETE FROM video_uploads WHERE video_id = ?`, id)
+	if err != nil {
+		return err
+	}
+
 	query := `
 	DELETE FROM videos
 	WHERE id = ?
 	`
-	_, err := c.db.Exec(query, id)
-	return err
+	_, err = c.exec(query, id)
+	if err != nil {
+		return err
+	}
+	return c.unindexVideo(id)
+}
+
+// SetVideoThumbnailIfMissing sets the thumbnail only if the video doesn't have one yet,
+// reporting whether it did
+func (c Client) SetVideoThumbnailIfMissing(id uuid.UUID, thumbnailURL string, thumbnails ThumbnailSet, mediaType string) (bool, error) {
+	query := `
+	UPDATE videos
+	SET thumbnail_url = ?, thumbnails = ?, thumbnail_media_type = ?, updated_at = ` + c.dialect.now() + `
+	WHERE id = ? AND thumbnail_url IS NULL
+	`
+	res, err := c.exec(query, thumbnailURL, thumbnails, mediaType, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
 }
@@ -0,0 +1,44 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestUpdateVideoDetails(t *testing.T) {
+	c := newTestClient(t)
+	video := createTestVideo(t, c, CreateVideoParams{Title: "old title", Description: "old description", UserID: createTestUser(t, c).ID})
+
+	// updated_at has millisecond precision
+	time.Sleep(2 * time.Millisecond)
+	title := "new title"
+	updated, err := c.UpdateVideoDetails(video.ID, UpdateVideoDetailsParams{Title: &title}, &video.UpdatedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Title != "new title" || updated.Description != "old description" || updated.Visibility != video.Visibility {
+		t.Errorf("after updating the title: %q, %q, %s", updated.Title, updated.Description, updated.Visibility)
+	}
+	if !updated.UpdatedAt.After(video.UpdatedAt) {
+		t.Errorf("updated_at stayed %v", updated.UpdatedAt)
+	}
+
+	// Based on the version before the first update
+	description := "lost update"
+	if _, err := c.UpdateVideoDetails(video.ID, UpdateVideoDetailsParams{Description: &description}, &video.UpdatedAt); !errors.Is(err, ErrVideoModified) {
+		t.Errorf("updating a stale version: error = %v, want ErrVideoModified", err)
+	}
+	got, err := c.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Description != "old description" {
+		t.Errorf("stale update wrote the description %q", got.Description)
+	}
+
+	// Without a version the last write wins
+	if _, err := c.UpdateVideoDetails(video.ID, UpdateVideoDetailsParams{Description: &description}, nil); err != nil {
+		t.Errorf("unconditional update: %v", err)
+	}
+}
@@ -0,0 +1,179 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// ErrPermanent can be wrapped by a handler to fail a job without retrying it
+var ErrPermanent = errors.New("permanent job failure")
+
+// Handler runs a single job. Returning an error schedules a retry
+// with backoff until the job runs out of attempts.
+type Handler func(ctx context.Context, job database.Job) error
+
+// Queue is a persistent job queue backed by the jobs table. Jobs survive
+// restarts: anything queued (or running when the server stopped) is
+// picked up again by the workers on the next Start.
+type Queue struct {
+	db           database.Client
+	workers      int
+	pollInterval time.Duration
+	baseBackoff  time.Duration
+	maxBackoff   time.Duration
+
+	mu       sync.RWMutex
+	handlers map[string]Handler
+	wake     chan struct{}
+}
+
+func NewQueue(db database.Client, workers int) *Queue {
+	if workers <= 0 {
+		workers = 1
+	}
+	return &Queue{
+		db:           db,
+		workers:      workers,
+		pollInterval: 2 * time.Second,
+		baseBackoff:  10 * time.Second,
+		maxBackoff:   10 * time.Minute,
+		handlers:     map[string]Handler{},
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// Handle registers the handler for a job type. Register every handler before calling Start.
+func (q *Queue) Handle(jobType string, handler Handler) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.handlers[jobType] = handler
+}
+
+// Enqueue stores a new job with its payload marshalled as JSON and wakes up an idle worker.
+func (q *Queue) Enqueue(jobType string, userID uuid.UUID, payload any, maxAttempts int) (database.Job, error) {
+	dat, err := json.Marshal(payload)
+	if err != nil {
+		return database.Job{}, fmt.Errorf("couldn't marshal job payload: %w", err)
+	}
+	job, err := q.db.CreateJob(database.CreateJobParams{
+		Type:        jobType,
+		UserID:      userID,
+		Payload:     string(dat),
+		MaxAttempts: maxAttempts,
+	})
+	if err != nil {
+		return database.Job{}, err
+	}
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return job, nil
+}
+
+// Start requeues jobs interrupted by the last shutdown and starts the worker goroutines.
+// Workers stop once ctx is cancelled.
+func (q *Queue) Start(ctx context.Context) error {
+	requeued, err := q.db.RequeueRunningJobs()
+	if err != nil {
+		return fmt.Errorf("couldn't requeue interrupted jobs: %w", err)
+	}
+	if requeued > 0 {
+		log.Printf("Requeued %d interrupted jobs", requeued)
+	}
+
+	for i := 0; i < q.workers; i++ {
+		go q.work(ctx)
+	}
+	return nil
+}
+
+func (q *Queue) work(ctx context.Context) {
+	for {
+		job, err := q.db.ClaimNextJob()
+		if err != nil {
+			log.Printf("Couldn't claim job: %v", err)
+		}
+		if job != nil {
+			q.run(ctx, *job)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.wake:
+		case <-time.After(q.pollInterval):
+		}
+	}
+}
+
+func (q *Queue) run(ctx context.Context, job database.Job) {
+	q.mu.RLock()
+	handler, ok := q.handlers[job.Type]
+	q.mu.RUnlock()
+
+	var err error
+	if !ok {
+		err = fmt.Errorf("no handler registered for job type %q", job.Type)
+	} else {
+		err = runHandler(ctx, handler, job)
+	}
+
+	if err == nil {
+		if err := q.db.CompleteJob(job.ID); err != nil {
+			log.Printf("Couldn't mark job %s as succeeded: %v", job.ID, err)
+		}
+		return
+	}
+
+	if !WillRetry(job, err) {
+		log.Printf("Job %s (%s) failed permanently after %d attempts: %v", job.ID, job.Type, job.Attempts, err)
+		if err := q.db.FailJob(job.ID, err.Error()); err != nil {
+			log.Printf("Couldn't mark job %s as failed: %v", job.ID, err)
+		}
+		return
+	}
+
+	runAt := time.Now().Add(q.backoff(job.Attempts))
+	log.Printf("Job %s (%s) failed on attempt %d, retrying at %s: %v", job.ID, job.Type, job.Attempts, runAt.Format(time.RFC3339), err)
+	if err := q.db.RetryJob(job.ID, err.Error(), runAt); err != nil {
+		log.Printf("Couldn't reschedule job %s: %v", job.ID, err)
+	}
+}
+
+// WillRetry reports whether the queue will run the job again after it failed with err.
+// Handlers use it to tell a transient failure from the final one.
+func WillRetry(job database.Job, err error) bool {
+	return err != nil && job.Attempts < job.MaxAttempts && !errors.Is(err, ErrPermanent)
+}
+
+// runHandler turns a panicking handler into a failed attempt instead of a dead worker
+func runHandler(ctx context.Context, handler Handler, job database.Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return handler(ctx, job)
+}
+
+// backoff doubles the delay with every attempt, with up to 20% jitter
+func (q *Queue) backoff(attempts int) time.Duration {
+	delay := q.baseBackoff
+	for i := 1; i < attempts && delay < q.maxBackoff; i++ {
+		delay *= 2
+	}
+	delay = min(delay, q.maxBackoff)
+	return delay + time.Duration(rand.Int64N(int64(delay)/5+1))
+}
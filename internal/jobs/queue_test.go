@@ -0,0 +1,204 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// newTestQueue returns a queue on a fresh database that retries right away
+func newTestQueue(t *testing.T) *Queue {
+	t.Helper()
+	db, err := database.NewClient(filepath.Join(t.TempDir(), "tubely.db"))
+	if err != nil {
+		t.Fatalf("couldn't open test database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	q := NewQueue(db, 1)
+	q.pollInterval = 10 * time.Millisecond
+	q.baseBackoff = 0
+	q.maxBackoff = 0
+	return q
+}
+
+// runNext claims the next due job and runs it like a worker would
+func runNext(t *testing.T, q *Queue) database.Job {
+	t.Helper()
+	job, err := q.db.ClaimNextJob()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job == nil {
+		t.Fatal("no job is due")
+	}
+	q.run(context.Background(), *job)
+	ran, err := q.db.GetJob(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ran
+}
+
+func TestQueueRetriesUntilSuccess(t *testing.T) {
+	q := newTestQueue(t)
+	calls := 0
+	q.Handle("flaky", func(ctx context.Context, job database.Job) error {
+		calls++
+		if calls == 1 {
+			return errors.New("connection reset")
+		}
+		if job.Payload != `{"n":1}` {
+			return fmt.Errorf("%w: payload %s", ErrPermanent, job.Payload)
+		}
+		return nil
+	})
+	if _, err := q.Enqueue("flaky", uuid.New(), map[string]int{"n": 1}, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	job := runNext(t, q)
+	if job.Status != database.JobStatusQueued || job.Attempts != 1 || job.LastError == nil || *job.LastError != "connection reset" {
+		t.Fatalf("after a failed attempt: %+v, want queued again with the error", job)
+	}
+	job = runNext(t, q)
+	if job.Status != database.JobStatusSucceeded || job.Attempts != 2 || job.LastError != nil {
+		t.Fatalf("after a successful retry: %+v, want succeeded", job)
+	}
+}
+
+func TestQueueFailures(t *testing.T) {
+	tests := []struct {
+		name        string
+		handler     Handler
+		maxAttempts int
+		status      database.JobStatus
+		lastError   string
+	}{
+		{
+			name:        "permanent",
+			handler:     func(ctx context.Context, job database.Job) error { return fmt.Errorf("%w: bad input", ErrPermanent) },
+			maxAttempts: 3,
+			status:      database.JobStatusFailed,
+			lastError:   "bad input",
+		},
+		{
+			name:        "out of attempts",
+			handler:     func(ctx context.Context, job database.Job) error { return errors.New("timeout") },
+			maxAttempts: 1,
+			status:      database.JobStatusFailed,
+			lastError:   "timeout",
+		},
+		{
+			name:        "panic",
+			handler:     func(ctx context.Context, job database.Job) error { panic("nil map") },
+			maxAttempts: 3,
+			status:      database.JobStatusQueued,
+			lastError:   "job panicked: nil map",
+		},
+		{
+			name:        "no handler",
+			maxAttempts: 1,
+			status:      database.JobStatusFailed,
+			lastError:   "no handler registered",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newTestQueue(t)
+			if tt.handler != nil {
+				q.Handle("test", tt.handler)
+			}
+			if _, err := q.Enqueue("test", uuid.New(), nil, tt.maxAttempts); err != nil {
+				t.Fatal(err)
+			}
+			job := runNext(t, q)
+			if job.Status != tt.status || job.LastError == nil || !strings.Contains(*job.LastError, tt.lastError) {
+				t.Errorf("job = %s with error %v, want %s with %q", job.Status, job.LastError, tt.status, tt.lastError)
+			}
+		})
+	}
+}
+
+func TestQueueStartRequeuesInterruptedJobs(t *testing.T) {
+	q := newTestQueue(t)
+	done := make(chan uuid.UUID, 2)
+	q.Handle("work", func(ctx context.Context, job database.Job) error {
+		done <- job.ID
+		return nil
+	})
+
+	// Claimed by a server that stopped before finishing it
+	interrupted, err := q.Enqueue("work", uuid.New(), nil, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job, err := q.db.ClaimNextJob(); err != nil || job == nil {
+		t.Fatalf("claiming = %v, %v", job, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := q.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	queued, err := q.Enqueue("work", uuid.New(), nil, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ran := map[uuid.UUID]bool{}
+	for len(ran) < 2 {
+		select {
+		case id := <-done:
+			ran[id] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only ran %v", ran)
+		}
+	}
+	if !ran[interrupted.ID] || !ran[queued.ID] {
+		t.Errorf("ran %v, want the interrupted and the new job", ran)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	q := NewQueue(database.Client{}, 1)
+	tests := []struct {
+		attempts int
+		min      time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{4, 80 * time.Second},
+		{20, 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		got := q.backoff(tt.attempts)
+		if got < tt.min || got > tt.min+tt.min/5 {
+			t.Errorf("backoff(%d) = %s, want %s plus up to 20%%", tt.attempts, got, tt.min)
+		}
+	}
+}
+
+func TestWillRetry(t *testing.T) {
+	job := database.Job{Attempts: 1, CreateJobParams: database.CreateJobParams{MaxAttempts: 3}}
+	if !WillRetry(job, errors.New("timeout")) {
+		t.Error("a transient failure with attempts left isn't retried")
+	}
+	if WillRetry(job, fmt.Errorf("%w: bad input", ErrPermanent)) {
+		t.Error("a permanent failure is retried")
+	}
+	if WillRetry(job, nil) {
+		t.Error("a success is retried")
+	}
+	job.Attempts = 3
+	if WillRetry(job, errors.New("timeout")) {
+		t.Error("a job out of attempts is retried")
+	}
+}
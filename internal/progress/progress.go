@@ -0,0 +1,213 @@
+package progress
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Event is a progress update for one video. Percent is how far along the
+// current stage is, ETASeconds is only set once there's enough to go on.
+type Event struct {
+	VideoID    uuid.UUID `json:"video_id"`
+	Stage      string    `json:"stage"`
+	Percent    float64   `json:"percent"`
+	ETASeconds *float64  `json:"eta_seconds"`
+	Done       bool      `json:"done"`
+	Error      string    `json:"error,omitempty"`
+}
+
+const (
+	StageUploading = "uploading"
+	StageQueued    = "queued"
+	StageProbing   = "probing"
+	StageFastStart = "faststart"
+	StageStoring   = "storing"
+	StageHLS       = "hls"
+	StageDASH      = "dash"
+	StageThumbnail = "thumbnail"
+	StageReady     = "ready"
+	StageFailed    = "failed"
+)
+
+// subscriberQueue is how many events a slow subscriber can fall behind by
+const subscriberQueue = 16
+
+// Hub fans progress events out to every subscriber watching a video.
+// It lives in memory, so subscribers only see work done by this server.
+type Hub struct {
+	mu          sync.Mutex
+	subscribers map[uuid.UUID]map[chan Event]struct{}
+	latest      map[uuid.UUID]Event
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		subscribers: map[uuid.UUID]map[chan Event]struct{}{},
+		latest:      map[uuid.UUID]Event{},
+	}
+}
+
+// Publish sends the event to every subscriber of its video. A subscriber that
+// isn't keeping up misses intermediate events rather than blocking the sender,
+// but always gets the final one, which tells it to stop listening.
+func (h *Hub) Publish(event Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if event.Done {
+		delete(h.latest, event.VideoID)
+	} else {
+		h.latest[event.VideoID] = event
+	}
+	for ch := range h.subscribers[event.VideoID] {
+		if event.Done {
+			sendDropping(ch, event)
+			continue
+		}
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// sendDropping sends the event, dropping the oldest queued events to make room.
+// Only the hub sends, under its lock, so this can't wait on another sender.
+func sendDropping(ch chan Event, event Event) {
+	for {
+		select {
+		case ch <- event:
+			return
+		default:
+		}
+		select {
+		case <-ch:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel of events for the video, the most recent
+// in-flight event if there is one, and a function to unsubscribe.
+func (h *Hub) Subscribe(videoID uuid.UUID) (<-chan Event, *Event, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ch := make(chan Event, subscriberQueue)
+	if h.subscribers[videoID] == nil {
+		h.subscribers[videoID] = map[chan Event]struct{}{}
+	}
+	h.subscribers[videoID][ch] = struct{}{}
+
+	var latest *Event
+	if event, ok := h.latest[videoID]; ok {
+		latest = &event
+	}
+
+	unsubscribe := func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		delete(h.subscribers[videoID], ch)
+		if len(h.subscribers[videoID]) == 0 {
+			delete(h.subscribers, videoID)
+		}
+	}
+	return ch, latest, unsubscribe
+}
+
+// Tracker publishes the progress of one piece of work on a video.
+// A nil *Tracker is valid and reports nothing.
+type Tracker struct {
+	hub        *Hub
+	videoID    uuid.UUID
+	stage      string
+	stageStart time.Time
+	lastSent   time.Time
+}
+
+func (h *Hub) Track(videoID uuid.UUID) *Tracker {
+	return &Tracker{hub: h, videoID: videoID}
+}
+
+// Stage starts a new stage at 0%
+func (t *Tracker) Stage(stage string) {
+	if t == nil {
+		return
+	}
+	t.stage = stage
+	t.stageStart = time.Now()
+	t.lastSent = time.Now()
+	t.hub.Publish(Event{VideoID: t.videoID, Stage: stage})
+}
+
+// Progress reports the fraction (0 to 1) of the current stage that's done.
+// Updates are throttled to a few per second.
+func (t *Tracker) Progress(fraction float64) {
+	if t == nil {
+		return
+	}
+	fraction = max(0, min(1, fraction))
+	if time.Since(t.lastSent) < 250*time.Millisecond && fraction < 1 {
+		return
+	}
+	t.lastSent = time.Now()
+
+	event := Event{
+		VideoID: t.videoID,
+		Stage:   t.stage,
+		Percent: fraction * 100,
+	}
+	// Extrapolate from the time spent so far once the estimate is meaningful
+	elapsed := time.Since(t.stageStart)
+	if fraction > 0.01 && elapsed > time.Second {
+		eta := (elapsed.Seconds() / fraction) - elapsed.Seconds()
+		event.ETASeconds = &eta
+	}
+	t.hub.Publish(event)
+}
+
+// Done publishes the final event, ready or failed depending on err
+func (t *Tracker) Done(err error) {
+	if t == nil {
+		return
+	}
+	event := Event{
+		VideoID: t.videoID,
+		Stage:   StageReady,
+		Percent: 100,
+		Done:    true,
+	}
+	if err != nil {
+		event.Stage = StageFailed
+		event.Percent = 0
+		event.Error = err.Error()
+	}
+	t.hub.Publish(event)
+}
+
+// Reader wraps r so reading through it reports progress against size bytes
+func (t *Tracker) Reader(r io.Reader, size int64) io.Reader {
+	if t == nil || size <= 0 {
+		return r
+	}
+	return &progressReader{r: r, size: size, tracker: t}
+}
+
+type progressReader struct {
+	r       io.Reader
+	size    int64
+	read    int64
+	tracker *Tracker
+}
+
+func (pr *progressReader) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	if n > 0 {
+		pr.read += int64(n)
+		pr.tracker.Progress(float64(pr.read) / float64(pr.size))
+	}
+	return n, err
+}
@@ -0,0 +1,64 @@
+package progress
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestPublishDeliversDoneToFullSubscriber(t *testing.T) {
+	hub := NewHub()
+	videoID := uuid.New()
+	events, _, unsubscribe := hub.Subscribe(videoID)
+	defer unsubscribe()
+
+	// Nobody reads, so the queue fills up and later progress is dropped
+	for i := 0; i < subscriberQueue*2; i++ {
+		hub.Publish(Event{VideoID: videoID, Stage: StageStoring, Percent: float64(i)})
+	}
+	hub.Publish(Event{VideoID: videoID, Stage: StageReady, Done: true})
+
+	var last Event
+	for len(events) > 0 {
+		last = <-events
+	}
+	if !last.Done {
+		t.Fatalf("last event = %+v, want the done event", last)
+	}
+}
+
+func TestPublishDropsProgressForFullSubscriber(t *testing.T) {
+	hub := NewHub()
+	videoID := uuid.New()
+	events, _, unsubscribe := hub.Subscribe(videoID)
+	defer unsubscribe()
+
+	for i := 0; i < subscriberQueue+5; i++ {
+		hub.Publish(Event{VideoID: videoID, Stage: StageStoring, Percent: float64(i)})
+	}
+	if len(events) != subscriberQueue {
+		t.Fatalf("queued %d events, want %d", len(events), subscriberQueue)
+	}
+	if first := <-events; first.Percent != 0 {
+		t.Errorf("first event has percent %v, want the oldest (0)", first.Percent)
+	}
+}
+
+func TestSubscribeReturnsLatestInFlightEvent(t *testing.T) {
+	hub := NewHub()
+	videoID := uuid.New()
+	hub.Publish(Event{VideoID: videoID, Stage: StageHLS, Percent: 40})
+
+	_, latest, unsubscribe := hub.Subscribe(videoID)
+	unsubscribe()
+	if latest == nil || latest.Stage != StageHLS {
+		t.Fatalf("latest = %+v, want the hls event", latest)
+	}
+
+	hub.Publish(Event{VideoID: videoID, Stage: StageReady, Done: true})
+	_, latest, unsubscribe = hub.Subscribe(videoID)
+	unsubscribe()
+	if latest != nil {
+		t.Fatalf("latest = %+v after done, want nil", latest)
+	}
+}
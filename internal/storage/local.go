@@ -0,0 +1,203 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type LocalStore struct {
+	root    string
+	baseURL string
+}
+
+// NewLocalStore returns a BlobStore that keeps objects as plain files under root.
+// baseURL should point at whatever serves root over HTTP (the /assets/ file server).
+func NewLocalStore(root, baseURL string) (*LocalStore, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, fmt.Errorf("couldn't create storage root %s: %w", root, err)
+	}
+	return &LocalStore{
+		root:    root,
+		baseURL: baseURL,
+	}, nil
+}
+
+func (s *LocalStore) Put(ctx context.Context, key string, body io.Reader, contentType string) error {
+	diskPath, err := s.diskPath(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(diskPath), 0755); err != nil {
+		return fmt.Errorf("couldn't create directory for %s: %w", key, err)
+	}
+
+	// Write to a temp file first so readers never see a half written object
+	tmp, err := os.CreateTemp(filepath.Dir(diskPath), ".upload-*")
+	if err != nil {
+		return fmt.Errorf("couldn't create file for %s: %w", key, err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err := io.Copy(tmp, body); err != nil {
+		return fmt.Errorf("couldn't write %s: %w", key, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("couldn't write %s: %w", key, err)
+	}
+	if err := os.Rename(tmp.Name(), diskPath); err != nil {
+		return fmt.Errorf("couldn't write %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *LocalStore) Get(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error) {
+	info, err := s.Head(ctx, key)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	diskPath, _ := s.diskPath(key)
+	f, err := os.Open(diskPath)
+	if err != nil {
+		return nil, ObjectInfo{}, s.wrapError(key, err)
+	}
+	return f, info, nil
+}
+
+// Move renames the file, touching it so LastModified is the time of the move
+func (s *LocalStore) Move(ctx context.Context, from, to string) error {
+	fromPath, err := s.diskPath(from)
+	if err != nil {
+		return err
+	}
+	toPath, err := s.diskPath(to)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(toPath), 0755); err != nil {
+		return fmt.Errorf("couldn't create directory for %s: %w", to, err)
+	}
+	if err := os.Rename(fromPath, toPath); err != nil {
+		return s.wrapError(from, err)
+	}
+	now := time.Now()
+	if err := os.Chtimes(toPath, now, now); err != nil {
+		return fmt.Errorf("couldn't touch %s: %w", to, err)
+	}
+	return nil
+}
+
+func (s *LocalStore) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, ObjectInfo, error) {
+	info, err := s.Head(ctx, key)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	diskPath, _ := s.diskPath(key)
+	f, err := os.Open(diskPath)
+	if err != nil {
+		return nil, ObjectInfo{}, s.wrapError(key, err)
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, ObjectInfo{}, fmt.Errorf("couldn't read %s: %w", key, err)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, length), f}, info, nil
+}
+
+func (s *LocalStore) Head(ctx context.Context, key string) (ObjectInfo, error) {
+	diskPath, err := s.diskPath(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	stat, err := os.Stat(diskPath)
+	if err != nil {
+		return ObjectInfo{}, s.wrapError(key, err)
+	}
+	if stat.IsDir() {
+		return ObjectInfo{}, fmt.Errorf("%s: %w", key, ErrNotFound)
+	}
+	return ObjectInfo{
+		Key:          key,
+		Size:         stat.Size(),
+		ContentType:  mime.TypeByExtension(path.Ext(key)),
+		LastModified: stat.ModTime(),
+	}, nil
+}
+
+func (s *LocalStore) Delete(ctx context.Context, key string) error {
+	diskPath, err := s.diskPath(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(diskPath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("couldn't delete %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *LocalStore) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	objects := []ObjectInfo{}
+	err := filepath.WalkDir(s.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".upload-") {
+			return nil
+		}
+		rel, err := filepath.Rel(s.root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		stat, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, ObjectInfo{
+			Key:          key,
+			Size:         stat.Size(),
+			ContentType:  mime.TypeByExtension(path.Ext(key)),
+			LastModified: stat.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list objects with prefix %q: %w", prefix, err)
+	}
+	return objects, nil
+}
+
+func (s *LocalStore) URL(key string) string {
+	return joinURL(s.baseURL, key)
+}
+
+func (s *LocalStore) diskPath(key string) (string, error) {
+	cleaned := path.Clean("/" + key)
+	if cleaned == "/" {
+		return "", fmt.Errorf("invalid key %q", key)
+	}
+	return filepath.Join(s.root, filepath.FromSlash(cleaned)), nil
+}
+
+func (s *LocalStore) wrapError(key string, err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%s: %w", key, ErrNotFound)
+	}
+	return fmt.Errorf("couldn't read %s: %w", key, err)
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ObjectReader reads an object as an io.ReadSeeker, so it can be handed to
+// http.ServeContent. Nothing is buffered: every seek that moves the position
+// starts a new ranged read from the store on the next Read.
+type ObjectReader struct {
+	ctx   context.Context
+	store BlobStore
+	info  ObjectInfo
+	pos   int64
+	body  io.ReadCloser
+}
+
+// NewObjectReader looks the object up and returns a reader positioned at its start
+func NewObjectReader(ctx context.Context, store BlobStore, key string) (*ObjectReader, error) {
+	info, err := store.Head(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectReader{
+		ctx:   ctx,
+		store: store,
+		info:  info,
+	}, nil
+}
+
+// Info describes the object as it was when the reader was created
+func (r *ObjectReader) Info() ObjectInfo {
+	return r.info
+}
+
+func (r *ObjectReader) Read(p []byte) (int, error) {
+	if r.pos >= r.info.Size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		body, _, err := r.store.GetRange(r.ctx, r.info.Key, r.pos, r.info.Size-r.pos)
+		if err != nil {
+			return 0, err
+		}
+		r.body = body
+	}
+	n, err := r.body.Read(p)
+	r.pos += int64(n)
+	if errors.Is(err, io.EOF) && r.pos < r.info.Size {
+		// The object got shorter since it was looked up
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (r *ObjectReader) Seek(offset int64, whence int) (int64, error) {
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = r.pos + offset
+	case io.SeekEnd:
+		pos = r.info.Size + offset
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if pos < 0 {
+		return 0, errors.New("negative position")
+	}
+	if pos != r.pos && r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+	r.pos = pos
+	return pos, nil
+}
+
+func (r *ObjectReader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
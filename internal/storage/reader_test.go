@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// rangeRecorder is a BlobStore that records the ranged reads made from it
+type rangeRecorder struct {
+	BlobStore
+	ranges [][2]int64
+}
+
+func (s *rangeRecorder) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, ObjectInfo, error) {
+	s.ranges = append(s.ranges, [2]int64{offset, length})
+	return s.BlobStore.GetRange(ctx, key, offset, length)
+}
+
+func TestObjectReader(t *testing.T) {
+	ctx := context.Background()
+	store := &rangeRecorder{BlobStore: NewMemoryStore("/assets")}
+	if err := store.Put(ctx, "videos/a.mp4", strings.NewReader("0123456789"), "video/mp4"); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewObjectReader(ctx, store, "videos/a.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if info := r.Info(); info.Size != 10 || info.ContentType != "video/mp4" {
+		t.Errorf("Info = %+v", info)
+	}
+	if len(store.ranges) != 0 {
+		t.Errorf("creating the reader read %v", store.ranges)
+	}
+
+	// What http.ServeContent does for bytes=6-7
+	if size, err := r.Seek(0, io.SeekEnd); err != nil || size != 10 {
+		t.Fatalf("Seek to end = %d, %v", size, err)
+	}
+	if _, err := r.Seek(6, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(r, buf); err != nil || string(buf) != "67" {
+		t.Fatalf("read %q, %v, want 67", buf, err)
+	}
+	if _, err := io.ReadFull(r, buf); err != nil || string(buf) != "89" {
+		t.Fatalf("second read %q, %v, want 89", buf, err)
+	}
+	if n, err := r.Read(buf); n != 0 || err != io.EOF {
+		t.Errorf("read past the end = %d, %v, want EOF", n, err)
+	}
+
+	if pos, err := r.Seek(-8, io.SeekCurrent); err != nil || pos != 2 {
+		t.Fatalf("Seek back = %d, %v", pos, err)
+	}
+	if _, err := io.ReadFull(r, buf); err != nil || string(buf) != "23" {
+		t.Fatalf("read %q after seeking back, %v, want 23", buf, err)
+	}
+	if want := [][2]int64{{6, 4}, {2, 8}}; len(store.ranges) != len(want) || store.ranges[0] != want[0] || store.ranges[1] != want[1] {
+		t.Errorf("ranged reads = %v, want %v", store.ranges, want)
+	}
+
+	if _, err := r.Seek(-1, io.SeekStart); err == nil {
+		t.Errorf("seeking before the start was allowed")
+	}
+}
+
+func TestObjectReaderTruncatedObject(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore("/assets")
+	if err := store.Put(ctx, "videos/a.mp4", strings.NewReader("0123456789"), "video/mp4"); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewObjectReader(ctx, store, "videos/a.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if err := store.Put(ctx, "videos/a.mp4", strings.NewReader("01234"), "video/mp4"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(r); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("reading an object that got shorter: error = %v, want ErrUnexpectedEOF", err)
+	}
+}
+
+func TestNewObjectReaderMissing(t *testing.T) {
+	_, err := NewObjectReader(context.Background(), NewMemoryStore("/assets"), "videos/missing.mp4")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("error = %v, want ErrNotFound", err)
+	}
+}
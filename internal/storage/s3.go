@@ -0,0 +1,218 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	// S3 rejects multipart parts smaller than 5 MiB (except the last one)
+	minPartSize     = 5 << 20
+	maxPartCount    = 10000
+	defaultPartSize = 16 << 20
+)
+
+// MultipartOptions controls how large objects are split into S3 multipart uploads.
+// Zero values fall back to the defaults.
+type MultipartOptions struct {
+	// PartSize is the size of each part in bytes, objects smaller than
+	// one part are sent with a single PutObject call.
+	PartSize int64
+	// Concurrency is how many parts are uploaded at the same time.
+	Concurrency int
+	// MaxRetries is how many times a failed part is retried before giving up.
+	MaxRetries int
+}
+
+type S3Store struct {
+	client    *s3.Client
+	bucket    string
+	baseURL   string
+	multipart MultipartOptions
+}
+
+// NewS3Store returns a BlobStore backed by an S3 bucket. Object URLs are
+// built from baseURL, usually the CloudFront distribution in front of the bucket.
+func NewS3Store(client *s3.Client, bucket, baseURL string, multipart MultipartOptions) *S3Store {
+	if multipart.PartSize == 0 {
+		multipart.PartSize = defaultPartSize
+	}
+	if multipart.PartSize < minPartSize {
+		multipart.PartSize = minPartSize
+	}
+	if multipart.Concurrency <= 0 {
+		multipart.Concurrency = 4
+	}
+	if multipart.MaxRetries < 0 {
+		multipart.MaxRetries = 0
+	}
+	return &S3Store{
+		client:    client,
+		bucket:    bucket,
+		baseURL:   baseURL,
+		multipart: multipart,
+	}
+}
+
+// Put sends small objects with a single PutObject and everything
+// larger than one part through a multipart upload.
+func (s *S3Store) Put(ctx context.Context, key string, body io.Reader, contentType string) error {
+	first := make([]byte, s.multipart.PartSize)
+	n, err := io.ReadFull(body, first)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return s.putObject(ctx, key, bytes.NewReader(first[:n]), contentType)
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't read body for %s: %w", key, err)
+	}
+	return s.putMultipart(ctx, key, io.MultiReader(bytes.NewReader(first), body), contentType)
+}
+
+func (s *S3Store) putObject(ctx context.Context, key string, body io.Reader, contentType string) error {
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        body,
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't put object %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *S3Store) Get(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, ObjectInfo{}, s.wrapError(key, err)
+	}
+	info := ObjectInfo{
+		Key:          key,
+		Size:         aws.ToInt64(out.ContentLength),
+		ContentType:  aws.ToString(out.ContentType),
+		LastModified: aws.ToTime(out.LastModified),
+	}
+	return out.Body, info, nil
+}
+
+func (s *S3Store) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, ObjectInfo, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+	})
+	if err != nil {
+		return nil, ObjectInfo{}, s.wrapError(key, err)
+	}
+	// Content-Length is only the range, the whole size is after the slash of
+	// Content-Range: bytes 0-99/1234
+	size := aws.ToInt64(out.ContentLength)
+	if contentRange := aws.ToString(out.ContentRange); contentRange != "" {
+		_, total, _ := strings.Cut(contentRange, "/")
+		if n, err := strconv.ParseInt(total, 10, 64); err == nil {
+			size = n
+		}
+	}
+	info := ObjectInfo{
+		Key:          key,
+		Size:         size,
+		ContentType:  aws.ToString(out.ContentType),
+		LastModified: aws.ToTime(out.LastModified),
+	}
+	return out.Body, info, nil
+}
+
+func (s *S3Store) Head(ctx context.Context, key string) (ObjectInfo, error) {
+	out, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return ObjectInfo{}, s.wrapError(key, err)
+	}
+	return ObjectInfo{
+		Key:          key,
+		Size:         aws.ToInt64(out.ContentLength),
+		ContentType:  aws.ToString(out.ContentType),
+		LastModified: aws.ToTime(out.LastModified),
+	}, nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't delete object %s: %w", key, err)
+	}
+	return nil
+}
+
+// Move copies the object server side with CopyObject, then deletes the
+// original. CopyObject handles objects up to 5 GB.
+func (s *S3Store) Move(ctx context.Context, from, to string) error {
+	// CopySource is bucket/key, URL encoded, with the slashes of the key kept
+	segments := strings.Split(from, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	_, err := s.client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(s.bucket),
+		Key:        aws.String(to),
+		CopySource: aws.String(s.bucket + "/" + strings.Join(segments, "/")),
+	})
+	if err != nil {
+		return s.wrapError(from, err)
+	}
+	return s.Delete(ctx, from)
+}
+
+func (s *S3Store) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	})
+
+	objects := []ObjectInfo{}
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't list objects with prefix %q: %w", prefix, err)
+		}
+		for _, obj := range page.Contents {
+			objects = append(objects, ObjectInfo{
+				Key:          aws.ToString(obj.Key),
+				Size:         aws.ToInt64(obj.Size),
+				LastModified: aws.ToTime(obj.LastModified),
+			})
+		}
+	}
+	return objects, nil
+}
+
+func (s *S3Store) URL(key string) string {
+	return joinURL(s.baseURL, key)
+}
+
+func (s *S3Store) wrapError(key string, err error) error {
+	var noSuchKey *types.NoSuchKey
+	var notFound *types.NotFound
+	if errors.As(err, &noSuchKey) || errors.As(err, &notFound) {
+		return fmt.Errorf("%s: %w", key, ErrNotFound)
+	}
+	return fmt.Errorf("couldn't get object %s: %w", key, err)
+}
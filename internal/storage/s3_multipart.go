@@ -0,0 +1,206 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// putMultipart streams body to S3 in parts of s.multipart.PartSize, uploading up to
+// s.multipart.Concurrency parts at once. At most Concurrency parts are held in memory.
+// If any part still fails after its retries the whole upload is aborted,
+// so S3 doesn't keep (and bill for) the parts that did make it.
+func (s *S3Store) putMultipart(ctx context.Context, key string, body io.Reader, contentType string) error {
+	created, err := s.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't start multipart upload for %s: %w", key, err)
+	}
+	uploadID := created.UploadId
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		completed []types.CompletedPart
+		firstErr  error
+	)
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+
+	sem := make(chan struct{}, s.multipart.Concurrency)
+	for partNumber := int32(1); ; partNumber++ {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		if partNumber > maxPartCount {
+			<-sem
+			fail(fmt.Errorf("%s needs more than %d parts, increase the part size", key, maxPartCount))
+			break
+		}
+
+		buf := make([]byte, s.multipart.PartSize)
+		n, readErr := io.ReadFull(body, buf)
+		if n > 0 {
+			wg.Add(1)
+			go func(partNumber int32, data []byte) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				etag, err := s.uploadPartWithRetry(ctx, key, uploadID, partNumber, data)
+				if err != nil {
+					fail(err)
+					return
+				}
+				mu.Lock()
+				completed = append(completed, types.CompletedPart{
+					ETag:       etag,
+					PartNumber: aws.Int32(partNumber),
+				})
+				mu.Unlock()
+			}(partNumber, buf[:n])
+		} else {
+			<-sem
+		}
+
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			fail(fmt.Errorf("couldn't read body for %s: %w", key, readErr))
+			break
+		}
+	}
+	wg.Wait()
+
+	if firstErr == nil && ctx.Err() != nil {
+		firstErr = ctx.Err()
+	}
+	if firstErr != nil {
+		s.abortMultipart(key, uploadID)
+		return fmt.Errorf("multipart upload of %s failed: %w", key, firstErr)
+	}
+
+	sort.Slice(completed, func(i, j int) bool {
+		return aws.ToInt32(completed[i].PartNumber) < aws.ToInt32(completed[j].PartNumber)
+	})
+	_, err = s.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(key),
+		UploadId:        uploadID,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		s.abortMultipart(key, uploadID)
+		return fmt.Errorf("couldn't complete multipart upload of %s: %w", key, err)
+	}
+	return nil
+}
+
+// uploadPartWithRetry uploads one part, retrying with exponential backoff
+func (s *S3Store) uploadPartWithRetry(ctx context.Context, key string, uploadID *string, partNumber int32, data []byte) (*string, error) {
+	backoff := 500 * time.Millisecond
+	var lastErr error
+	for attempt := 0; attempt <= s.multipart.MaxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(backoff):
+				backoff *= 2
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		out, err := s.client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:        aws.String(s.bucket),
+			Key:           aws.String(key),
+			UploadId:      uploadID,
+			PartNumber:    aws.Int32(partNumber),
+			Body:          bytes.NewReader(data),
+			ContentLength: aws.Int64(int64(len(data))),
+		})
+		if err == nil {
+			return out.ETag, nil
+		}
+		if errors.Is(err, context.Canceled) {
+			return nil, err
+		}
+		lastErr = err
+		log.Printf("Upload of part %d of %s failed (attempt %d/%d): %v", partNumber, key, attempt+1, s.multipart.MaxRetries+1, err)
+	}
+	return nil, fmt.Errorf("part %d: %w", partNumber, lastErr)
+}
+
+// abortMultipart discards the parts of a failed upload. It runs on its own
+// context because the request context is usually what got cancelled.
+func (s *S3Store) abortMultipart(key string, uploadID *string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	_, err := s.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(key),
+		UploadId: uploadID,
+	})
+	if err != nil {
+		log.Printf("Couldn't abort multipart upload of %s, parts may be orphaned: %v", key, err)
+	}
+}
+
+// AbortStaleMultipartUploads aborts every incomplete multipart upload in the bucket
+// that was started more than olderThan ago, e.g. because the server crashed
+// mid upload. It returns how many uploads were aborted.
+func (s *S3Store) AbortStaleMultipartUploads(ctx context.Context, olderThan time.Duration) (int, error) {
+	cutoff := time.Now().Add(-olderThan)
+	aborted := 0
+
+	input := &s3.ListMultipartUploadsInput{Bucket: aws.String(s.bucket)}
+	for {
+		page, err := s.client.ListMultipartUploads(ctx, input)
+		if err != nil {
+			return aborted, fmt.Errorf("couldn't list multipart uploads: %w", err)
+		}
+		for _, upload := range page.Uploads {
+			if upload.Initiated == nil || upload.Initiated.After(cutoff) {
+				continue
+			}
+			_, err := s.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+				Bucket:   aws.String(s.bucket),
+				Key:      upload.Key,
+				UploadId: upload.UploadId,
+			})
+			if err != nil {
+				return aborted, fmt.Errorf("couldn't abort multipart upload of %s: %w", aws.ToString(upload.Key), err)
+			}
+			aborted++
+		}
+		if !aws.ToBool(page.IsTruncated) {
+			return aborted, nil
+		}
+		input.KeyMarker = page.NextKeyMarker
+		input.UploadIdMarker = page.NextUploadIdMarker
+	}
+}
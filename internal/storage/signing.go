@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// URLSigner hands out URLs to stored objects that stop working after ttl
+type URLSigner interface {
+	SignedURL(ctx context.Context, key string, ttl time.Duration) (string, error)
+}
+
+// PrefixSigner is a URLSigner that can also sign a URL that grants access to
+// every object under a prefix, for manifests whose segments are fetched by
+// URLs relative to them
+type PrefixSigner interface {
+	URLSigner
+	// SignedPrefixURL returns the URL of key with a signature that's also
+	// valid for every other object whose key starts with prefix + "/"
+	SignedPrefixURL(ctx context.Context, key, prefix string, ttl time.Duration) (string, error)
+}
+
+// MaxPresignTTL is the longest S3 accepts for a presigned URL
+const MaxPresignTTL = 7 * 24 * time.Hour
+
+// SignedURL returns a presigned GetObject URL. It points straight at the
+// bucket, not at the URL base the store was created with.
+func (s *S3Store) SignedURL(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	req, err := s3.NewPresignClient(s.client).PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}, s3.WithPresignExpires(ttl))
+	if err != nil {
+		return "", fmt.Errorf("couldn't presign %s: %w", key, err)
+	}
+	return req.URL, nil
+}
+
+// CloudFrontSigner signs the URLs of a store served through a CloudFront
+// distribution that only accepts signed requests. Single objects get a canned
+// policy, prefixes a custom policy with a wildcard resource.
+type CloudFrontSigner struct {
+	store     BlobStore
+	keyPairID string
+	key       *rsa.PrivateKey
+}
+
+// NewCloudFrontSigner signs store's URLs with the distribution's key pair.
+// privateKeyPEM is the RSA private key in PKCS #1 or PKCS #8 PEM form.
+func NewCloudFrontSigner(store BlobStore, keyPairID string, privateKeyPEM []byte) (*CloudFrontSigner, error) {
+	block, _ := pem.Decode(privateKeyPEM)
+	if block == nil {
+		return nil, errors.New("no PEM encoded private key found")
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		parsed, pkcs8Err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if pkcs8Err != nil {
+			return nil, fmt.Errorf("couldn't parse private key: %w", pkcs8Err)
+		}
+		var ok bool
+		key, ok = parsed.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("CloudFront private key must be an RSA key")
+		}
+	}
+	return &CloudFrontSigner{
+		store:     store,
+		keyPairID: keyPairID,
+		key:       key,
+	}, nil
+}
+
+func (s *CloudFrontSigner) SignedURL(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	// The policy has to name the URL exactly as it's requested, escaped
+	u, err := url.Parse(s.store.URL(key))
+	if err != nil {
+		return "", err
+	}
+	resource := u.String()
+	expires := time.Now().Add(ttl)
+
+	// A canned policy is left out of the URL, CloudFront rebuilds it from
+	// the URL and Expires
+	_, signature, err := s.signPolicy(resource, expires)
+	if err != nil {
+		return "", err
+	}
+	return appendQuery(u, url.Values{
+		"Expires":     {strconv.FormatInt(expires.Unix(), 10)},
+		"Signature":   {signature},
+		"Key-Pair-Id": {s.keyPairID},
+	}), nil
+}
+
+// SignedPrefixURL signs key's URL with a custom policy for everything under
+// prefix. The same Policy, Signature and Key-Pair-Id work for the other
+// objects, as query parameters or as CloudFront-* cookies.
+func (s *CloudFrontSigner) SignedPrefixURL(ctx context.Context, key, prefix string, ttl time.Duration) (string, error) {
+	u, err := url.Parse(s.store.URL(key))
+	if err != nil {
+		return "", err
+	}
+	prefixURL, err := url.Parse(s.store.URL(strings.TrimSuffix(prefix, "/")))
+	if err != nil {
+		return "", err
+	}
+
+	policy, signature, err := s.signPolicy(prefixURL.String()+"/*", time.Now().Add(ttl))
+	if err != nil {
+		return "", err
+	}
+	return appendQuery(u, url.Values{
+		"Policy":      {cloudFrontBase64(policy)},
+		"Signature":   {signature},
+		"Key-Pair-Id": {s.keyPairID},
+	}), nil
+}
+
+// signPolicy returns the policy statement for resource and its signature,
+// encoded for a query parameter
+func (s *CloudFrontSigner) signPolicy(resource string, expires time.Time) ([]byte, string, error) {
+	type condition struct {
+		DateLessThan struct {
+			EpochTime int64 `json:"AWS:EpochTime"`
+		}
+	}
+	type statement struct {
+		Resource  string
+		Condition condition
+	}
+	policy := struct {
+		Statement []statement
+	}{Statement: []statement{{Resource: resource}}}
+	policy.Statement[0].Condition.DateLessThan.EpochTime = expires.Unix()
+
+	// The signature covers the exact policy CloudFront rebuilds from the URL,
+	// so the resource can't have its & escaped
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(policy); err != nil {
+		return nil, "", err
+	}
+	dat := bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+	// CloudFront only accepts SHA-1 signatures of the policy
+	hash := sha1.Sum(dat)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA1, hash[:])
+	if err != nil {
+		return nil, "", fmt.Errorf("couldn't sign %s: %w", resource, err)
+	}
+	return dat, cloudFrontBase64(signature), nil
+}
+
+// appendQuery adds params to u's query. They're appended rather than
+// re-encoded so a query already in the URL keeps its order.
+func appendQuery(u *url.URL, params url.Values) string {
+	separator := "?"
+	if u.RawQuery != "" {
+		separator = "&"
+	}
+	return u.String() + separator + params.Encode()
+}
+
+// cloudFrontBase64 is base64 with the characters that aren't safe in a query swapped out
+func cloudFrontBase64(b []byte) string {
+	return strings.NewReplacer("+", "-", "=", "_", "/", "~").Replace(base64.StdEncoding.EncodeToString(b))
+}
@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testDistribution = "https://d111111abcdef8.cloudfront.net"
+
+func newTestCloudFrontSigner(t *testing.T) (*CloudFrontSigner, *rsa.PublicKey) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	signer, err := NewCloudFrontSigner(NewMemoryStore(testDistribution), "K2JCJMDEHXQW5F", keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer, &key.PublicKey
+}
+
+// verifyCloudFrontSignature checks signature the way CloudFront does, against the public key
+func verifyCloudFrontSignature(t *testing.T, pub *rsa.PublicKey, policy []byte, signature string) {
+	t.Helper()
+	sig, err := base64.StdEncoding.DecodeString(strings.NewReplacer("-", "+", "_", "=", "~", "/").Replace(signature))
+	if err != nil {
+		t.Fatalf("signature %q isn't CloudFront base64: %v", signature, err)
+	}
+	hash := sha1.Sum(policy)
+	if err := rsa.VerifyPKCS1v15(pub, crypto.SHA1, hash[:], sig); err != nil {
+		t.Fatalf("signature doesn't match policy %s: %v", policy, err)
+	}
+}
+
+func TestCloudFrontSignedURL(t *testing.T) {
+	signer, pub := newTestCloudFrontSigner(t)
+	before := time.Now()
+	signed, err := signer.SignedURL(context.Background(), "videos/landscape/my clip.mp4", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+	if query.Get("Key-Pair-Id") != "K2JCJMDEHXQW5F" || query.Has("Policy") {
+		t.Errorf("canned policy URL has query %v", query)
+	}
+	expires, err := strconv.ParseInt(query.Get("Expires"), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := before.Add(time.Hour).Unix(); expires < want || expires > want+1 {
+		t.Errorf("Expires = %d, want %d", expires, want)
+	}
+
+	// CloudFront rebuilds the canned policy from the URL without its signing parameters
+	resource := testDistribution + "/videos/landscape/my%20clip.mp4"
+	if !strings.HasPrefix(signed, resource+"?") {
+		t.Fatalf("signed URL %s isn't for %s", signed, resource)
+	}
+	policy := fmt.Sprintf(`{"Statement":[{"Resource":"%s","Condition":{"DateLessThan":{"AWS:EpochTime":%d}}}]}`, resource, expires)
+	verifyCloudFrontSignature(t, pub, []byte(policy), query.Get("Signature"))
+}
+
+func TestCloudFrontSignedPrefixURL(t *testing.T) {
+	signer, pub := newTestCloudFrontSigner(t)
+	signed, err := signer.SignedPrefixURL(context.Background(), "videos/abc/hls/master.m3u8", "videos/abc/hls/", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(signed, testDistribution+"/videos/abc/hls/master.m3u8?") {
+		t.Fatalf("signed URL %s isn't for the manifest", signed)
+	}
+
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+	if query.Has("Expires") {
+		t.Errorf("custom policy URL has Expires, CloudFront would take it for a canned policy")
+	}
+	policy, err := base64.StdEncoding.DecodeString(strings.NewReplacer("-", "+", "_", "=", "~", "/").Replace(query.Get("Policy")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyCloudFrontSignature(t, pub, policy, query.Get("Signature"))
+
+	var statement struct {
+		Statement []struct {
+			Resource  string
+			Condition struct {
+				DateLessThan struct {
+					EpochTime int64 `json:"AWS:EpochTime"`
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal(policy, &statement); err != nil {
+		t.Fatal(err)
+	}
+	if len(statement.Statement) != 1 || statement.Statement[0].Resource != testDistribution+"/videos/abc/hls/*" {
+		t.Fatalf("policy = %s, want one statement for everything under the HLS prefix", policy)
+	}
+	if statement.Statement[0].Condition.DateLessThan.EpochTime <= time.Now().Unix() {
+		t.Errorf("policy has already expired: %s", policy)
+	}
+}
+
+func TestNewCloudFrontSignerKeys(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs1 := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})
+	if _, err := NewCloudFrontSigner(NewMemoryStore(testDistribution), "K", pkcs1); err != nil {
+		t.Errorf("PKCS #1 key rejected: %v", err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ec := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if _, err := NewCloudFrontSigner(NewMemoryStore(testDistribution), "K", ec); err == nil {
+		t.Errorf("ECDSA key accepted, CloudFront only takes RSA")
+	}
+	if _, err := NewCloudFrontSigner(NewMemoryStore(testDistribution), "K", []byte("not a key")); err == nil {
+		t.Errorf("garbage accepted as a key")
+	}
+}
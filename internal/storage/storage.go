@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+var ErrNotFound = errors.New("object not found")
+
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	LastModified time.Time
+}
+
+// BlobStore is the interface every storage backend (S3, local filesystem,
+// in-memory) implements. Keys are always slash separated, relative paths.
+type BlobStore interface {
+	Put(ctx context.Context, key string, body io.Reader, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error)
+	// GetRange reads at most length bytes starting at offset. The info
+	// describes the whole object.
+	GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, ObjectInfo, error)
+	Head(ctx context.Context, key string) (ObjectInfo, error)
+	Delete(ctx context.Context, key string) error
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	URL(key string) string
+}
+
+// Mover is a BlobStore that can move an object without reading it back
+// through the client. The moved object's LastModified is the time of the move.
+type Mover interface {
+	Move(ctx context.Context, from, to string) error
+}
+
+func joinURL(baseURL, key string) string {
+	return strings.TrimSuffix(baseURL, "/") + "/" + strings.TrimPrefix(key, "/")
+}
+
+// KeyFromURL turns a URL handed out by store.URL back into its key.
+// It reports false for URLs that don't belong to the store.
+func KeyFromURL(store BlobStore, url string) (string, bool) {
+	key, ok := strings.CutPrefix(url, store.URL(""))
+	if !ok || key == "" {
+		return "", false
+	}
+	return key, true
+}
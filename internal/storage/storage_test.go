@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testStores returns an empty store of every backend that doesn't need a network
+func testStores(t *testing.T) map[string]BlobStore {
+	local, err := NewLocalStore(t.TempDir(), "http://localhost:8091/assets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]BlobStore{
+		"local":  local,
+		"memory": NewMemoryStore("http://localhost:8091/assets"),
+	}
+}
+
+func readObject(t *testing.T, body io.ReadCloser) string {
+	t.Helper()
+	defer body.Close()
+	dat, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(dat)
+}
+
+func TestBlobStores(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := store.Put(ctx, "thumbnails/a.png", strings.NewReader("0123456789"), "image/png"); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Put(ctx, "videos/b.png", strings.NewReader("video"), "image/png"); err != nil {
+				t.Fatal(err)
+			}
+
+			body, info, err := store.Get(ctx, "thumbnails/a.png")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := readObject(t, body); got != "0123456789" {
+				t.Errorf("Get = %q", got)
+			}
+			if info.Key != "thumbnails/a.png" || info.Size != 10 || info.ContentType != "image/png" || info.LastModified.IsZero() {
+				t.Errorf("Get info = %+v", info)
+			}
+
+			body, info, err = store.GetRange(ctx, "thumbnails/a.png", 2, 3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := readObject(t, body); got != "234" {
+				t.Errorf("GetRange(2, 3) = %q, want 234", got)
+			}
+			if info.Size != 10 {
+				t.Errorf("GetRange info has size %d, want the whole object's 10", info.Size)
+			}
+
+			objects, err := store.List(ctx, "thumbnails/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(objects) != 1 || objects[0].Key != "thumbnails/a.png" {
+				t.Errorf("List(thumbnails/) = %+v", objects)
+			}
+
+			url := store.URL("thumbnails/a.png")
+			if url != "http://localhost:8091/assets/thumbnails/a.png" {
+				t.Errorf("URL = %q", url)
+			}
+			if key, ok := KeyFromURL(store, url); !ok || key != "thumbnails/a.png" {
+				t.Errorf("KeyFromURL(%q) = %q, %v", url, key, ok)
+			}
+			if _, ok := KeyFromURL(store, "https://elsewhere.example.com/thumbnails/a.png"); ok {
+				t.Errorf("KeyFromURL accepted another store's URL")
+			}
+
+			if err := store.Delete(ctx, "thumbnails/a.png"); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Delete(ctx, "thumbnails/a.png"); err != nil {
+				t.Errorf("deleting a missing object: %v", err)
+			}
+			if _, err := store.Head(ctx, "thumbnails/a.png"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Head after Delete: error = %v, want ErrNotFound", err)
+			}
+			if _, _, err := store.Get(ctx, "thumbnails/a.png"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Get after Delete: error = %v, want ErrNotFound", err)
+			}
+
+			objects, err = store.List(ctx, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var keys []string
+			for _, obj := range objects {
+				keys = append(keys, obj.Key)
+			}
+			if !slices.Equal(keys, []string{"videos/b.png"}) {
+				t.Errorf("List() = %v, want only videos/b.png", keys)
+			}
+		})
+	}
+}
+
+func TestLocalStoreKeepsKeysUnderRoot(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	store, err := NewLocalStore(root+"/assets", "/assets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(ctx, "../escaped.png", strings.NewReader("x"), "image/png"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Head(ctx, "escaped.png"); err != nil {
+		t.Errorf("../escaped.png wasn't stored under the root: %v", err)
+	}
+	if err := store.Put(ctx, "/", strings.NewReader("x"), "image/png"); err == nil {
+		t.Errorf("the root itself was accepted as a key")
+	}
+}
+
+func TestLocalStoreMove(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewLocalStore(t.TempDir(), "/assets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(ctx, "thumbnails/a.png", strings.NewReader("png"), "image/png"); err != nil {
+		t.Fatal(err)
+	}
+	diskPath, err := store.diskPath("thumbnails/a.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(diskPath, stored, stored); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Move(ctx, "thumbnails/a.png", "quarantine/thumbnails/a.png"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Head(ctx, "thumbnails/a.png"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("source still exists after Move: %v", err)
+	}
+	body, info, err := store.Get(ctx, "quarantine/thumbnails/a.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readObject(t, body); got != "png" {
+		t.Errorf("moved object = %q", got)
+	}
+	// The quarantine period counts from the move
+	if info.LastModified.Before(time.Now().Add(-time.Minute)) {
+		t.Errorf("LastModified = %v after the move, want the time of the move", info.LastModified)
+	}
+
+	if err := store.Move(ctx, "thumbnails/missing.png", "quarantine/missing.png"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("moving a missing object: error = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,133 @@
+package thumbnail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+)
+
+// Widths are the variant widths generated for every thumbnail
+var Widths = []int{160, 320, 640, 1280}
+
+const (
+	// maxPixels keeps a decompression bomb from eating all our memory
+	maxPixels   = 40_000_000
+	jpegQuality = 85
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported image format")
+	ErrTooLarge          = errors.New("image dimensions too large")
+)
+
+// Variant is one re-encoded JPEG size of a thumbnail
+type Variant struct {
+	Width  int
+	Height int
+	Data   []byte
+}
+
+// Decode reads a JPEG or PNG, checking its dimensions before decoding the pixels
+func Decode(r io.ReadSeeker) (image.Image, error) {
+	config, format, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedFormat, err)
+	}
+	if format != "jpeg" && format != "png" {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxPixels {
+		return nil, fmt.Errorf("%w: %dx%d", ErrTooLarge, config.Width, config.Height)
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't decode %s: %w", format, err)
+	}
+	return img, nil
+}
+
+// Variants scales src down to each of Widths, never upscaling. An image narrower
+// than the smallest width gets a single variant at its own size.
+func Variants(src image.Image) ([]Variant, error) {
+	// Flatten onto white once, JPEG has no alpha and the scaler wants plain RGBA
+	bounds := src.Bounds()
+	current := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(current, current.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(current, current.Bounds(), src, bounds.Min, draw.Over)
+
+	widths := []int{}
+	for _, w := range Widths {
+		if w <= bounds.Dx() {
+			widths = append(widths, w)
+		}
+	}
+	if len(widths) == 0 {
+		widths = []int{bounds.Dx()}
+	}
+
+	// Scale from the largest down, each step starting from the previous one
+	variants := make([]Variant, len(widths))
+	for i := len(widths) - 1; i >= 0; i-- {
+		current = scale(current, widths[i])
+
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, current, &jpeg.Options{Quality: jpegQuality}); err != nil {
+			return nil, fmt.Errorf("couldn't encode %dpx thumbnail: %w", widths[i], err)
+		}
+		variants[i] = Variant{
+			Width:  current.Rect.Dx(),
+			Height: current.Rect.Dy(),
+			Data:   buf.Bytes(),
+		}
+	}
+	return variants, nil
+}
+
+// scale resizes src to width, keeping the aspect ratio, by averaging
+// the block of source pixels each destination pixel covers
+func scale(src *image.RGBA, width int) *image.RGBA {
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+	if width >= sw {
+		return src
+	}
+	height := max(1, (sh*width+sw/2)/sw)
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		y0 := y * sh / height
+		y1 := max(y0+1, (y+1)*sh/height)
+		for x := 0; x < width; x++ {
+			x0 := x * sw / width
+			x1 := max(x0+1, (x+1)*sw/width)
+
+			var r, g, b, a, n int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r += int(p[0])
+					g += int(p[1])
+					b += int(p[2])
+					a += int(p[3])
+					n++
+				}
+			}
+			d := dst.Pix[y*dst.Stride+x*4:]
+			d[0] = uint8(r / n)
+			d[1] = uint8(g / n)
+			d[2] = uint8(b / n)
+			d[3] = uint8(a / n)
+		}
+	}
+	return dst
+}
@@ -0,0 +1,114 @@
+package thumbnail
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// withPNGSize rewrites the dimensions in a PNG's header, leaving the pixels alone
+func withPNGSize(dat []byte, width, height uint32) []byte {
+	dat = bytes.Clone(dat)
+	// 8 byte signature, then the IHDR chunk's length and type
+	binary.BigEndian.PutUint32(dat[16:], width)
+	binary.BigEndian.PutUint32(dat[20:], height)
+	binary.BigEndian.PutUint32(dat[29:], crc32.ChecksumIEEE(dat[12:29]))
+	return dat
+}
+
+func TestDecode(t *testing.T) {
+	small := encodePNG(t, image.NewRGBA(image.Rect(0, 0, 4, 3)))
+	var gifData bytes.Buffer
+	if err := gif.Encode(&gifData, image.NewPaletted(image.Rect(0, 0, 4, 3), color.Palette{color.Black}), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"png", small, nil},
+		{"gif", gifData.Bytes(), ErrUnsupportedFormat},
+		{"not an image", []byte("<html><script>alert(1)</script></html>"), ErrUnsupportedFormat},
+		{"decompression bomb", withPNGSize(small, 10_000, 10_000), ErrTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := Decode(bytes.NewReader(tt.data))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error = %v, want %v", err, tt.err)
+			}
+			if err == nil && img.Bounds().Dx() != 4 {
+				t.Errorf("decoded %v", img.Bounds())
+			}
+		})
+	}
+}
+
+func TestVariants(t *testing.T) {
+	tests := []struct {
+		name  string
+		width int
+		sizes [][2]int
+	}{
+		{"large", 1920, [][2]int{{160, 90}, {320, 180}, {640, 360}, {1280, 720}}},
+		{"never upscaled", 500, [][2]int{{160, 90}, {320, 180}}},
+		{"narrower than every width", 96, [][2]int{{96, 54}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			variants, err := Variants(image.NewRGBA(image.Rect(0, 0, tt.width, tt.width*9/16)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(variants) != len(tt.sizes) {
+				t.Fatalf("got %d variants, want %d", len(variants), len(tt.sizes))
+			}
+			for i, v := range variants {
+				if v.Width != tt.sizes[i][0] || v.Height != tt.sizes[i][1] {
+					t.Errorf("variant %d is %dx%d, want %dx%d", i, v.Width, v.Height, tt.sizes[i][0], tt.sizes[i][1])
+				}
+				config, err := jpeg.DecodeConfig(bytes.NewReader(v.Data))
+				if err != nil {
+					t.Fatalf("variant %d isn't a JPEG: %v", i, err)
+				}
+				if config.Width != v.Width || config.Height != v.Height {
+					t.Errorf("variant %d encodes %dx%d, reports %dx%d", i, config.Width, config.Height, v.Width, v.Height)
+				}
+			}
+		})
+	}
+}
+
+func TestVariantsFlattenOntoWhite(t *testing.T) {
+	// Fully transparent, which would come out black without flattening
+	variants, err := Variants(image.NewNRGBA(image.Rect(0, 0, 200, 100)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(variants[0].Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, g, b, _ := img.At(80, 40).RGBA()
+	if r>>8 < 250 || g>>8 < 250 || b>>8 < 250 {
+		t.Errorf("transparent pixel came out as %d,%d,%d, want white", r>>8, g>>8, b>>8)
+	}
+}
@@ -2,19 +2,26 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/progress"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
 
 	"github.com/joho/godotenv"
-	_ "github.com/lib/pq"
 )
 
-// Add an s3Client field to apiConfig of type *s3.Client
+// Uploads go through the BlobStore interface instead of a raw *s3.Client,
+// so the backend can be swapped with the STORAGE_BACKEND env var.
 type apiConfig struct {
 	db               database.Client
 	jwtSecret        string
@@ -25,7 +32,29 @@ type apiConfig struct {
 	s3Region         string
 	s3CfDistribution string
 	port             string
-	s3Client         *s3.Client
+	storageBackend   string
+	uploadDir        string
+	hlsEnabled       bool
+	dashEnabled      bool
+	// thumbnailOptions picks the frame used when a video has no thumbnail
+	thumbnailOptions thumbnailOptions
+	// videoStore holds the processed video files
+	videoStore storage.BlobStore
+	// assetStore holds thumbnails, served from /assets/
+	assetStore storage.BlobStore
+	// videoURLSigner makes the expiring URLs private videos are played from,
+	// nil when the storage backend can't sign URLs
+	videoURLSigner storage.URLSigner
+	signedURLTTL   time.Duration
+	// jobs runs slow work (ffmpeg, uploads to storage) in the background
+	jobs *jobs.Queue
+	// progress fans processing progress out to the SSE events endpoint
+	progress *progress.Hub
+	// trashRetention is how long deleted videos can be restored before they're purged
+	trashRetention time.Duration
+	// tusUploadExpiry is how long an unfinished resumable upload is kept after its last write
+	tusUploadExpiry time.Duration
+	tusLocks        *uploadLocks
 }
 
 // Because the thumbnail_url has all the data we need,
@@ -40,15 +69,32 @@ type apiConfig struct {
 func main() {
 	godotenv.Load(".env")
 
-	pathToDB := os.Getenv("DB_PATH")
+	// DB_URL is a postgres:// URL or a SQLite path, DB_PATH is the older name for the latter
+	pathToDB := os.Getenv("DB_URL")
+	if pathToDB == "" {
+		pathToDB = os.Getenv("DB_PATH")
+	}
 	if pathToDB == "" {
 		log.Fatal("DB_URL must be set")
 	}
 
+	// `tubely migrate up|down|status` manages the schema instead of serving
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrateCommand(pathToDB, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	db, err := database.NewClient(pathToDB)
 	if err != nil {
 		log.Fatalf("Couldn't connect to database: %v", err)
 	}
+	if db.SearchMode() == "like" {
+		log.Print("Video search falls back to an unranked substring match, build with -tags sqlite_fts5 for full-text search")
+	} else {
+		log.Printf("Video search uses %s", db.SearchMode())
+	}
 
 	jwtSecret := os.Getenv("JWT_SECRET")
 	if jwtSecret == "" {
@@ -70,33 +116,132 @@ func main() {
 		log.Fatal("ASSETS_ROOT environment variable is not set")
 	}
 
-	s3Bucket := os.Getenv("S3_BUCKET")
-	if s3Bucket == "" {
-		log.Fatal("S3_BUCKET environment variable is not set")
+	port := os.Getenv("PORT")
+	if port == "" {
+		log.Fatal("PORT environment variable is not set")
 	}
 
-	s3Region := os.Getenv("S3_REGION")
-	if s3Region == "" {
-		log.Fatal("S3_REGION environment variable is not set")
+	// UPLOAD_DIR is where partial (tus) uploads are staged until they're complete
+	uploadDir := os.Getenv("UPLOAD_DIR")
+	if uploadDir == "" {
+		uploadDir = filepath.Join(os.TempDir(), "tubely-uploads")
+	}
+	if err := os.MkdirAll(uploadDir, 0755); err != nil {
+		log.Fatalf("Couldn't create upload directory: %v", err)
+	}
+
+	// STORAGE_BACKEND is one of "s3" (the default), "local" or "memory".
+	// Only the s3 backend needs the S3_* variables and AWS credentials.
+	storageBackend := os.Getenv("STORAGE_BACKEND")
+	if storageBackend == "" {
+		storageBackend = "s3"
 	}
 
+	s3Bucket := os.Getenv("S3_BUCKET")
+	s3Region := os.Getenv("S3_REGION")
 	s3CfDistribution := os.Getenv("S3_CF_DISTRO")
-	if s3CfDistribution == "" {
-		log.Fatal("S3_CF_DISTRO environment variable is not set")
+
+	assetsBaseURL := fmt.Sprintf("http://localhost:%s/assets", port)
+
+	var videoStore, assetStore storage.BlobStore
+	var videoURLSigner storage.URLSigner
+	// SIGNED_URL_TTL is how long the URLs handed out for private videos work
+	signedURLTTL := getEnvDuration("SIGNED_URL_TTL", 15*time.Minute)
+	if signedURLTTL <= 0 {
+		log.Fatal("SIGNED_URL_TTL must be positive")
 	}
+	switch storageBackend {
+	case "s3":
+		if s3Bucket == "" {
+			log.Fatal("S3_BUCKET environment variable is not set")
+		}
+		if s3Region == "" {
+			log.Fatal("S3_REGION environment variable is not set")
+		}
+		if s3CfDistribution == "" {
+			log.Fatal("S3_CF_DISTRO environment variable is not set")
+		}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		log.Fatal("PORT environment variable is not set")
+		// Use config.LoadDefaultConfig to auto load the default AWS SDK config (the keys you set with aws configure)
+		awsCfg, err := config.LoadDefaultConfig(context.Background(), config.WithRegion(s3Region))
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		// Create a client with your config using s3.NewFromConfig
+		client := s3.NewFromConfig(awsCfg)
+		// Large videos go up as S3 multipart uploads, tuned with
+		// S3_PART_SIZE_MB, S3_UPLOAD_CONCURRENCY and S3_PART_RETRIES.
+		s3Store := storage.NewS3Store(client, s3Bucket, s3CfDistribution, storage.MultipartOptions{
+			PartSize:    int64(getEnvInt("S3_PART_SIZE_MB", 16)) << 20,
+			Concurrency: getEnvInt("S3_UPLOAD_CONCURRENCY", 4),
+			MaxRetries:  getEnvInt("S3_PART_RETRIES", 3),
+		})
+		// Clean up parts left behind by uploads that never finished (e.g. a crash mid upload)
+		go func() {
+			aborted, err := s3Store.AbortStaleMultipartUploads(context.Background(), 24*time.Hour)
+			if err != nil {
+				log.Printf("Couldn't clean up stale multipart uploads: %v", err)
+			} else if aborted > 0 {
+				log.Printf("Aborted %d stale multipart uploads", aborted)
+			}
+		}()
+		videoStore = s3Store
+
+		// Private videos are played from CloudFront signed URLs when the distribution's
+		// key pair is set with CLOUDFRONT_KEY_PAIR_ID and CLOUDFRONT_PRIVATE_KEY_PATH,
+		// and from S3 presigned URLs otherwise
+		if keyPairID := os.Getenv("CLOUDFRONT_KEY_PAIR_ID"); keyPairID != "" {
+			privateKey, err := os.ReadFile(os.Getenv("CLOUDFRONT_PRIVATE_KEY_PATH"))
+			if err != nil {
+				log.Fatalf("Couldn't read CloudFront private key: %v", err)
+			}
+			videoURLSigner, err = storage.NewCloudFrontSigner(s3Store, keyPairID, privateKey)
+			if err != nil {
+				log.Fatalf("Couldn't load CloudFront private key: %v", err)
+			}
+		} else {
+			if signedURLTTL > storage.MaxPresignTTL {
+				log.Fatalf("SIGNED_URL_TTL can't be longer than %s for S3 presigned URLs", storage.MaxPresignTTL)
+			}
+			videoURLSigner = s3Store
+		}
+
+		assetStore, err = storage.NewLocalStore(assetsRoot, assetsBaseURL)
+		if err != nil {
+			log.Fatalf("Couldn't create asset storage: %v", err)
+		}
+	case "local":
+		localStore, err := storage.NewLocalStore(assetsRoot, assetsBaseURL)
+		if err != nil {
+			log.Fatalf("Couldn't create local storage: %v", err)
+		}
+		videoStore = localStore
+		assetStore = localStore
+	case "memory":
+		videoStore = storage.NewMemoryStore(assetsBaseURL)
+		assetStore = storage.NewMemoryStore(assetsBaseURL)
+	default:
+		log.Fatalf("Unknown STORAGE_BACKEND %q, must be one of s3, local or memory", storageBackend)
 	}
 
-	// Use config.LoadDefaultConfig to auto load the default AWS SDK config (the keys you set with aws configure)
-	awsCfg, err := config.LoadDefaultConfig(context.Background())
-	if err != nil {
-		log.Fatal(err.Error())
+	// Videos without an uploaded thumbnail get a frame THUMBNAIL_TIMESTAMP seconds in,
+	// or the first scene change with THUMBNAIL_SCENE_DETECTION
+	thumbnailOpts := thumbnailOptions{
+		Timestamp:      time.Duration(getEnvInt("THUMBNAIL_TIMESTAMP", 1)) * time.Second,
+		SceneDetection: getEnvBool("THUMBNAIL_SCENE_DETECTION"),
+	}
+
+	// TRASH_RETENTION is how long deleted videos stay in the trash
+	trashRetention := getEnvDuration("TRASH_RETENTION", 30*24*time.Hour)
+	if trashRetention <= 0 {
+		log.Fatal("TRASH_RETENTION must be positive")
+	}
+
+	// TUS_UPLOAD_EXPIRY is how long an abandoned resumable upload is kept
+	tusUploadExpiry := getEnvDuration("TUS_UPLOAD_EXPIRY", 24*time.Hour)
+	if tusUploadExpiry <= 0 {
+		log.Fatal("TUS_UPLOAD_EXPIRY must be positive")
 	}
-	// Create a client with your config using s3.NewFromConfig
-	client := s3.NewFromConfig(awsCfg)
 
 	cfg := apiConfig{
 		db:               db,
@@ -108,8 +253,21 @@ func main() {
 		s3Region:         s3Region,
 		s3CfDistribution: s3CfDistribution,
 		port:             port,
-		// Assign the client to the s3Client field
-		s3Client: client,
+		storageBackend:   storageBackend,
+		uploadDir:        uploadDir,
+		hlsEnabled:       getEnvBool("HLS_ENABLED"),
+		dashEnabled:      getEnvBool("DASH_ENABLED"),
+		thumbnailOptions: thumbnailOpts,
+		videoStore:       videoStore,
+		assetStore:       assetStore,
+		videoURLSigner:   videoURLSigner,
+		signedURLTTL:     signedURLTTL,
+		// JOB_WORKERS is how many videos are processed in parallel
+		jobs:            jobs.NewQueue(db, getEnvInt("JOB_WORKERS", 2)),
+		progress:        progress.NewHub(),
+		trashRetention:  trashRetention,
+		tusUploadExpiry: tusUploadExpiry,
+		tusLocks:        newUploadLocks(),
 	}
 
 	err = cfg.ensureAssetsDir()
@@ -117,12 +275,45 @@ func main() {
 		log.Fatalf("Couldn't create assets directory: %v", err)
 	}
 
+	// Every ORPHAN_GC_INTERVAL (off by default), objects no video references anymore
+	// are quarantined once they're older than ORPHAN_GC_GRACE_PERIOD, and deleted
+	// after ORPHAN_GC_QUARANTINE_PERIOD. ORPHAN_GC_DRY_RUN only logs what would be done.
+	orphanGC := orphanGCOptions{
+		Interval:         getEnvDuration("ORPHAN_GC_INTERVAL", 0),
+		GracePeriod:      getEnvDuration("ORPHAN_GC_GRACE_PERIOD", 24*time.Hour),
+		QuarantinePeriod: getEnvDuration("ORPHAN_GC_QUARANTINE_PERIOD", 7*24*time.Hour),
+		DryRun:           getEnvBool("ORPHAN_GC_DRY_RUN"),
+	}
+
+	// `tubely gc [-dry-run] [-grace 24h] [-quarantine 168h]` runs the cleanup once instead of serving
+	if len(os.Args) > 1 && os.Args[1] == "gc" {
+		if err := cfg.runGCCommand(os.Args[2:], orphanGC); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	cfg.registerJobHandlers()
+	err = cfg.jobs.Start(context.Background())
+	if err != nil {
+		log.Fatalf("Couldn't start job workers: %v", err)
+	}
+	cfg.startOrphanGC(context.Background(), orphanGC)
+	cfg.startTrashPurge(context.Background())
+	cfg.startTusUploadSweep(context.Background())
+
 	mux := http.NewServeMux()
 	appHandler := http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))
 	mux.Handle("/app/", appHandler)
 
-	assetsHandler := http.StripPrefix("/assets", http.FileServer(http.Dir(assetsRoot)))
-	mux.Handle("/assets/", noCacheMiddleware(assetsHandler))
+	var assetsHandler http.Handler
+	if storageBackend == "memory" {
+		// Nothing is on disk, so serve assets straight out of the stores
+		assetsHandler = http.StripPrefix("/assets/", http.HandlerFunc(cfg.handlerStoredAsset))
+	} else {
+		assetsHandler = http.StripPrefix("/assets", http.FileServer(http.Dir(assetsRoot)))
+	}
+	mux.Handle("/assets/", noCacheMiddleware(hideQuarantine(assetsHandler)))
 
 	mux.HandleFunc("POST /api/login", cfg.handlerLogin)
 	mux.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
@@ -132,13 +323,30 @@ func main() {
 
 	mux.HandleFunc("POST /api/videos", cfg.handlerVideoMetaCreate)
 	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.handlerUploadThumbnail)
+	mux.HandleFunc("POST /api/videos/{videoID}/thumbnail/generate", cfg.handlerThumbnailGenerate)
 	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.handlerUploadVideo)
+	mux.HandleFunc("OPTIONS /api/video_upload/{videoID}/tus", cfg.handlerTusOptions)
+	mux.HandleFunc("POST /api/video_upload/{videoID}/tus", cfg.handlerTusCreate)
+	mux.HandleFunc("OPTIONS /api/video_upload/{videoID}/tus/{uploadID}", cfg.handlerTusOptions)
+	mux.HandleFunc("HEAD /api/video_upload/{videoID}/tus/{uploadID}", cfg.handlerTusHead)
+	mux.HandleFunc("PATCH /api/video_upload/{videoID}/tus/{uploadID}", cfg.handlerTusPatch)
+	mux.HandleFunc("DELETE /api/video_upload/{videoID}/tus/{uploadID}", cfg.handlerTusDelete)
 	mux.HandleFunc("GET /api/videos", cfg.handlerVideosRetrieve)
+	mux.HandleFunc("GET /api/videos/search", cfg.handlerVideosSearch)
+	mux.HandleFunc("GET /api/videos/trash", cfg.handlerVideosTrash)
+	mux.HandleFunc("GET /api/videos/public", cfg.handlerVideosPublic)
 	mux.HandleFunc("GET /api/videos/{videoID}", cfg.handlerVideoGet)
+	mux.HandleFunc("PATCH /api/videos/{videoID}", cfg.handlerVideoMetaUpdate)
+	mux.HandleFunc("GET /api/videos/{videoID}/status", cfg.handlerVideoStatusGet)
+	mux.HandleFunc("GET /api/videos/{videoID}/events", cfg.handlerVideoEvents)
+	mux.HandleFunc("GET /api/videos/{videoID}/stream", cfg.handlerVideoStream)
 	// Because the thumbnail_url has all the data we need,
 	// delete the global thumbnail map and the GET route for thumbnails.
 	// mux.HandleFunc("GET /api/thumbnails/{videoID}", cfg.handlerThumbnailGet)
 	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.handlerVideoMetaDelete)
+	mux.HandleFunc("POST /api/videos/{videoID}/restore", cfg.handlerVideoRestore)
+
+	mux.HandleFunc("GET /api/jobs/{jobID}", cfg.handlerJobGet)
 
 	mux.HandleFunc("POST /admin/reset", cfg.handlerReset)
 
@@ -150,3 +358,42 @@ func main() {
 	log.Printf("Serving on: http://localhost:%s/app/\n", port)
 	log.Fatal(srv.ListenAndServe())
 }
+
+// getEnvBool reads an optional boolean environment variable, unset means false
+func getEnvBool(name string) bool {
+	value := os.Getenv(name)
+	if value == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Fatalf("%s must be a boolean: %v", name, err)
+	}
+	return b
+}
+
+// getEnvInt reads an optional integer environment variable
+func getEnvInt(name string, fallback int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("%s must be an integer: %v", name, err)
+	}
+	return n
+}
+
+// getEnvDuration reads an optional duration environment variable like "6h" or "30m"
+func getEnvDuration(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("%s must be a duration like 6h or 30m: %v", name, err)
+	}
+	return d
+}
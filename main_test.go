@@ -0,0 +1,34 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// newTestDB opens a fresh, fully migrated SQLite database that's removed after the test
+func newTestDB(t *testing.T) database.Client {
+	t.Helper()
+	db, err := database.NewClient(filepath.Join(t.TempDir(), "tubely.db"))
+	if err != nil {
+		t.Fatalf("couldn't open test database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// createTestVideo makes a user with one draft video
+func createTestVideo(t *testing.T, db database.Client) database.Video {
+	t.Helper()
+	user, err := db.CreateUser(database.CreateUserParams{Email: uuid.NewString() + "@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatalf("couldn't create user: %v", err)
+	}
+	video, err := db.CreateVideo(database.CreateVideoParams{Title: "clip", UserID: user.ID})
+	if err != nil {
+		t.Fatalf("couldn't create video: %v", err)
+	}
+	return video
+}
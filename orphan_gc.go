@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// orphanGCOptions configures the reconciler that cleans up stored objects
+// no video references anymore, like replaced thumbnails and re-uploaded videos
+type orphanGCOptions struct {
+	// Interval between runs in the server, zero disables it
+	Interval time.Duration
+	// GracePeriod protects objects stored recently, which may belong to a
+	// video that's still processing and isn't referenced yet
+	GracePeriod time.Duration
+	// QuarantinePeriod is how long orphans sit under quarantinePrefix, where
+	// they can still be restored, before they're deleted for good
+	QuarantinePeriod time.Duration
+	// DryRun only reports what would be done
+	DryRun bool
+}
+
+// quarantinePrefix is where orphans are moved instead of being deleted right away.
+// A quarantined object that's referenced again is moved back. /assets/ doesn't
+// serve anything under it, see hideQuarantine.
+const quarantinePrefix = "quarantine/"
+
+// orphanAction is what the reconciler does with an object
+type orphanAction string
+
+const (
+	// orphanKeep leaves an object in its grace or quarantine period alone until a later run
+	orphanKeep orphanAction = "keep"
+	// orphanQuarantine moves an unreferenced object under quarantinePrefix
+	orphanQuarantine orphanAction = "quarantine"
+	// orphanDelete deletes an object that's been in quarantine for the whole quarantine period
+	orphanDelete orphanAction = "delete"
+	// orphanRestore moves a quarantined object that's referenced again back where it was
+	orphanRestore orphanAction = "restore"
+)
+
+// orphanedObject is an unreferenced or quarantined object found by the reconciler
+type orphanedObject struct {
+	storedObject
+	Size         int64
+	LastModified time.Time
+	Action       orphanAction
+	// Done is false in a dry run and when the action failed
+	Done bool
+}
+
+type orphanGCReport struct {
+	DryRun  bool
+	Scanned int
+	Orphans []orphanedObject
+	// FailedDeletions is how many deletions recorded when their video was deleted
+	// were retried, and Resolved how many of those succeeded this time
+	FailedDeletions int
+	Resolved        int
+}
+
+type orphanCounts struct {
+	Quarantined      int
+	QuarantinedBytes int64
+	Deleted          int
+	Restored         int
+	Kept             int
+	// Failed actions, always zero in a dry run
+	Failed int
+}
+
+// summary counts the orphans by what happened to them, or would in a dry run
+func (r orphanGCReport) summary() orphanCounts {
+	var counts orphanCounts
+	for _, o := range r.Orphans {
+		if o.Action != orphanKeep && !o.Done && !r.DryRun {
+			counts.Failed++
+			continue
+		}
+		switch o.Action {
+		case orphanKeep:
+			counts.Kept++
+		case orphanQuarantine:
+			counts.Quarantined++
+			counts.QuarantinedBytes += o.Size
+		case orphanDelete:
+			counts.Deleted++
+		case orphanRestore:
+			counts.Restored++
+		}
+	}
+	return counts
+}
+
+func (r orphanGCReport) String() string {
+	c := r.summary()
+	if r.DryRun {
+		return fmt.Sprintf("scanned %d objects: %d orphaned (%d bytes) would be quarantined, %d quarantined would be deleted, %d would be restored, %d kept for now, %d failed deletions to retry",
+			r.Scanned, c.Quarantined, c.QuarantinedBytes, c.Deleted, c.Restored, c.Kept, r.FailedDeletions)
+	}
+	return fmt.Sprintf("scanned %d objects: quarantined %d orphaned (%d bytes), deleted %d, restored %d, %d failed, %d kept for now, resolved %d of %d failed deletions",
+		r.Scanned, c.Quarantined, c.QuarantinedBytes, c.Deleted, c.Restored, c.Failed, c.Kept, r.Resolved, r.FailedDeletions)
+}
+
+// label describes the action for the gc command's report
+func (o orphanedObject) label(dryRun bool) string {
+	switch {
+	case o.Action == orphanKeep:
+		return "keep"
+	case dryRun:
+		return string(o.Action)
+	case !o.Done:
+		return string(o.Action) + " failed"
+	}
+	switch o.Action {
+	case orphanQuarantine:
+		return "quarantined"
+	case orphanDelete:
+		return "deleted"
+	}
+	return "restored"
+}
+
+// startOrphanGC runs the reconciler every interval until ctx is cancelled
+func (cfg *apiConfig) startOrphanGC(ctx context.Context, opts orphanGCOptions) {
+	if opts.Interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(opts.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			report, err := cfg.collectOrphans(ctx, opts)
+			if err != nil {
+				log.Printf("Orphaned object cleanup failed: %v", err)
+				continue
+			}
+			log.Printf("Orphaned object cleanup: %s", report)
+		}
+	}()
+}
+
+// collectOrphans lists every store and quarantines the objects no video
+// references once they're older than the grace period. Quarantined objects are
+// deleted after the quarantine period, or restored if they're referenced again.
+// It also retries the deletions that failed when their video was deleted.
+func (cfg *apiConfig) collectOrphans(ctx context.Context, opts orphanGCOptions) (orphanGCReport, error) {
+	report := orphanGCReport{DryRun: opts.DryRun}
+
+	failed, err := cfg.db.GetFailedDeletions(1000)
+	if err != nil {
+		return report, fmt.Errorf("couldn't get failed deletions: %w", err)
+	}
+	report.FailedDeletions = len(failed)
+	if !opts.DryRun {
+		for _, f := range failed {
+			if err := cfg.deleteStoredObject(ctx, storedObject{f.Store, f.Key}); err != nil {
+				log.Printf("Still can't delete %s: %v", f.Key, err)
+				continue
+			}
+			if err := cfg.db.ResolveFailedDeletion(f.ID); err != nil {
+				return report, err
+			}
+			report.Resolved++
+		}
+	}
+
+	// The local backend uses one store for both, scan it once
+	stores := []string{storeNameVideo}
+	if cfg.assetStore != cfg.videoStore {
+		stores = append(stores, storeNameAsset)
+	}
+
+	// List before reading references: anything stored after the listing isn't
+	// considered, and anything referenced before it shows up in the references
+	listings := map[string][]storage.ObjectInfo{}
+	for _, name := range stores {
+		store, _ := cfg.blobStore(name)
+		objects, err := store.List(ctx, "")
+		if err != nil {
+			return report, fmt.Errorf("couldn't list the %s store: %w", name, err)
+		}
+		listings[name] = objects
+	}
+
+	referenced, err := cfg.referencedObjects()
+	if err != nil {
+		return report, err
+	}
+
+	graceCutoff := time.Now().Add(-opts.GracePeriod)
+	quarantineCutoff := time.Now().Add(-opts.QuarantinePeriod)
+	for _, name := range stores {
+		store, _ := cfg.blobStore(name)
+		for _, info := range listings[name] {
+			report.Scanned++
+			orphan := orphanedObject{
+				storedObject: storedObject{name, info.Key},
+				Size:         info.Size,
+				LastModified: info.LastModified,
+				Action:       orphanKeep,
+			}
+
+			// A quarantined object's modification time is when it was quarantined
+			if original, ok := strings.CutPrefix(info.Key, quarantinePrefix); ok {
+				switch {
+				case referenced.contains(name, original):
+					orphan.Action = orphanRestore
+				case info.LastModified.Before(quarantineCutoff):
+					orphan.Action = orphanDelete
+				}
+			} else if referenced.contains(name, info.Key) {
+				continue
+			} else if info.LastModified.Before(graceCutoff) {
+				orphan.Action = orphanQuarantine
+			}
+
+			if !opts.DryRun && orphan.Action != orphanKeep {
+				var err error
+				switch orphan.Action {
+				case orphanQuarantine:
+					err = moveObject(ctx, store, info.Key, quarantinePrefix+info.Key)
+				case orphanRestore:
+					err = moveObject(ctx, store, info.Key, strings.TrimPrefix(info.Key, quarantinePrefix))
+				case orphanDelete:
+					err = store.Delete(ctx, info.Key)
+				}
+				if err != nil {
+					log.Printf("Couldn't %s orphaned %s: %v", orphan.Action, info.Key, err)
+				} else {
+					orphan.Done = true
+				}
+			}
+			report.Orphans = append(report.Orphans, orphan)
+		}
+	}
+	return report, nil
+}
+
+// moveObject moves an object to a new key, in place if the store can,
+// otherwise by copying it through the server and deleting the old one
+func moveObject(ctx context.Context, store storage.BlobStore, from, to string) error {
+	if mover, ok := store.(storage.Mover); ok {
+		return mover.Move(ctx, from, to)
+	}
+	body, info, err := store.Get(ctx, from)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	if err := store.Put(ctx, to, body, info.ContentType); err != nil {
+		return err
+	}
+	return store.Delete(ctx, from)
+}
+
+// objectReferences are the keys and prefixes in use, per store
+type objectReferences struct {
+	keys     map[storedObject]bool
+	prefixes map[storedObject]bool
+}
+
+func (r objectReferences) contains(store, key string) bool {
+	if r.keys[storedObject{store, key}] {
+		return true
+	}
+	// Renditions are referenced through the prefix they're stored under
+	for i, c := range key {
+		if c == '/' && r.prefixes[storedObject{store, key[:i+1]}] {
+			return true
+		}
+	}
+	return false
+}
+
+// referencedObjects collects what every video in the database points at.
+// When both names map to one store, references are filed under the video store.
+func (cfg *apiConfig) referencedObjects() (objectReferences, error) {
+	videos, err := cfg.db.GetAllVideos()
+	if err != nil {
+		return objectReferences{}, fmt.Errorf("couldn't get videos: %w", err)
+	}
+
+	refs := objectReferences{keys: map[storedObject]bool{}, prefixes: map[storedObject]bool{}}
+	for _, video := range videos {
+		// A URL outside the stores most likely means their base URL changed (PORT,
+		// S3_CF_DISTRO), and then everything would look unreferenced
+		if url, ok := cfg.unmappedURL(video); ok {
+			return objectReferences{}, fmt.Errorf("video %s references %s, which isn't in any store, not collecting orphans until it's fixed", video.ID, url)
+		}
+		for _, object := range cfg.videoObjects(video) {
+			if object.Store == storeNameAsset && cfg.assetStore == cfg.videoStore {
+				object.Store = storeNameVideo
+			}
+			if object.isPrefix() {
+				refs.prefixes[object] = true
+			} else {
+				refs.keys[object] = true
+			}
+		}
+	}
+	return refs, nil
+}
+
+// unmappedURL returns the first of the video's URLs that doesn't belong to the store it's kept in
+func (cfg *apiConfig) unmappedURL(video database.Video) (string, bool) {
+	for _, url := range []*string{video.VideoURL, video.HLSURL, video.DASHURL} {
+		if url == nil {
+			continue
+		}
+		if _, ok := storage.KeyFromURL(cfg.videoStore, *url); !ok {
+			return *url, true
+		}
+	}
+	thumbnailURLs := []string{}
+	if video.ThumbnailURL != nil {
+		thumbnailURLs = append(thumbnailURLs, *video.ThumbnailURL)
+	}
+	for _, url := range video.Thumbnails {
+		thumbnailURLs = append(thumbnailURLs, url)
+	}
+	for _, url := range thumbnailURLs {
+		if _, ok := storage.KeyFromURL(cfg.assetStore, url); !ok {
+			return url, true
+		}
+	}
+	return "", false
+}
+
+// hideQuarantine answers 404 for quarantined objects, which are still in the
+// stores the /assets/ handler serves
+func hideQuarantine(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The mux has already cleaned the path, so no dot segments get around this
+		key := strings.TrimPrefix(r.URL.Path, "/assets/")
+		if key+"/" == quarantinePrefix || strings.HasPrefix(key, quarantinePrefix) {
+			http.NotFound(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+func TestCollectOrphans(t *testing.T) {
+	ctx := context.Background()
+	cfg := &apiConfig{
+		db:         newTestDB(t),
+		videoStore: storage.NewMemoryStore("http://localhost:8091/videos"),
+		assetStore: storage.NewMemoryStore("http://localhost:8091/assets"),
+	}
+	put := func(store storage.BlobStore, key string) {
+		t.Helper()
+		if err := store.Put(ctx, key, strings.NewReader(key), "application/octet-stream"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exists := func(store storage.BlobStore, key string) bool {
+		_, err := store.Head(ctx, key)
+		return err == nil
+	}
+
+	video := createTestVideo(t, cfg.db)
+	videoURL := cfg.videoStore.URL("landscape/abc.mp4")
+	hlsURL := cfg.videoStore.URL("landscape/abc/hls/master.m3u8")
+	if err := cfg.db.SetVideoFiles(video.ID, videoURL, "video/mp4", &hlsURL, nil); err != nil {
+		t.Fatal(err)
+	}
+	put(cfg.videoStore, "landscape/abc.mp4")
+	put(cfg.videoStore, "landscape/abc/hls/720p/segment_000.ts")
+	// The referenced manifest was quarantined by an earlier run
+	put(cfg.videoStore, "quarantine/landscape/abc/hls/master.m3u8")
+	put(cfg.videoStore, "landscape/replaced.mp4")
+	put(cfg.videoStore, "quarantine/landscape/older.mp4")
+	put(cfg.assetStore, "thumbnails/replaced.jpg")
+	time.Sleep(5 * time.Millisecond)
+
+	report, err := cfg.collectOrphans(ctx, orphanGCOptions{GracePeriod: time.Hour, QuarantinePeriod: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := report.summary(); got.Quarantined != 0 || got.Deleted != 0 || got.Restored != 1 || got.Kept != 3 {
+		t.Errorf("within the grace and quarantine periods: %+v, want only the restore", got)
+	}
+	if !exists(cfg.videoStore, "landscape/abc/hls/master.m3u8") {
+		t.Errorf("referenced manifest wasn't restored")
+	}
+
+	report, err = cfg.collectOrphans(ctx, orphanGCOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := report.summary(); got.Quarantined != 2 || got.Deleted != 1 {
+		t.Errorf("dry run: %+v, want 2 quarantined and 1 deleted", got)
+	}
+	if !exists(cfg.videoStore, "landscape/replaced.mp4") || !exists(cfg.videoStore, "quarantine/landscape/older.mp4") {
+		t.Fatalf("dry run changed the store")
+	}
+
+	report, err = cfg.collectOrphans(ctx, orphanGCOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := report.summary(); got.Quarantined != 2 || got.Deleted != 1 || got.Failed != 0 {
+		t.Errorf("after the grace and quarantine periods: %+v, want 2 quarantined and 1 deleted", got)
+	}
+	for store, keys := range map[storage.BlobStore][]string{
+		cfg.videoStore: {
+			"landscape/abc.mp4",
+			"landscape/abc/hls/master.m3u8",
+			"landscape/abc/hls/720p/segment_000.ts",
+			"quarantine/landscape/replaced.mp4",
+		},
+		cfg.assetStore: {"quarantine/thumbnails/replaced.jpg"},
+	} {
+		for _, key := range keys {
+			if !exists(store, key) {
+				t.Errorf("%s is missing", key)
+			}
+		}
+	}
+	for _, key := range []string{"landscape/replaced.mp4", "quarantine/landscape/older.mp4"} {
+		if exists(cfg.videoStore, key) {
+			t.Errorf("%s is still there", key)
+		}
+	}
+}
+
+func TestCollectOrphansRefusesUnmappedURLs(t *testing.T) {
+	cfg := &apiConfig{
+		db:         newTestDB(t),
+		videoStore: storage.NewMemoryStore("http://localhost:8091/assets"),
+	}
+	cfg.assetStore = cfg.videoStore
+	video := createTestVideo(t, cfg.db)
+	if err := cfg.db.SetVideoFiles(video.ID, "http://localhost:9999/assets/landscape/abc.mp4", "video/mp4", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cfg.collectOrphans(context.Background(), orphanGCOptions{}); err == nil {
+		t.Errorf("collected orphans while a video points outside the stores")
+	}
+}
+
+func TestHideQuarantine(t *testing.T) {
+	handler := hideQuarantine(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	for path, want := range map[string]int{
+		"/assets/thumbnails/a.jpg":            http.StatusOK,
+		"/assets/quarantined.jpg":             http.StatusOK,
+		"/assets/quarantine":                  http.StatusNotFound,
+		"/assets/quarantine/":                 http.StatusNotFound,
+		"/assets/quarantine/thumbnails/a.jpg": http.StatusNotFound,
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != want {
+			t.Errorf("GET %s = %d, want %d", path, w.Code, want)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// ffprobeStream is the subset of an ffprobe stream we care about
+type ffprobeStream struct {
+	Index             int    `json:"index"`
+	CodecType         string `json:"codec_type"`
+	CodecName         string `json:"codec_name"`
+	Width             int    `json:"width"`
+	Height            int    `json:"height"`
+	SampleAspectRatio string `json:"sample_aspect_ratio"`
+	AvgFrameRate      string `json:"avg_frame_rate"`
+	RFrameRate        string `json:"r_frame_rate"`
+	BitRate           string `json:"bit_rate"`
+	Channels          int    `json:"channels"`
+	Tags              struct {
+		Rotate string `json:"rotate"`
+	} `json:"tags"`
+	SideDataList []struct {
+		Rotation *float64 `json:"rotation"`
+	} `json:"side_data_list"`
+	Disposition struct {
+		AttachedPic int `json:"attached_pic"`
+	} `json:"disposition"`
+}
+
+// videoProbe is what ffprobe -show_format -show_streams reports about a file
+type videoProbe struct {
+	Streams []ffprobeStream `json:"streams"`
+	Format  struct {
+		Duration string `json:"duration"`
+		Size     string `json:"size"`
+		BitRate  string `json:"bit_rate"`
+	} `json:"format"`
+}
+
+func probeVideo(filePath string) (videoProbe, error) {
+	cmd := exec.Command("ffprobe",
+		"-v", "error",
+		"-print_format", "json",
+		"-show_format",
+		"-show_streams",
+		filePath,
+	)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		return videoProbe{}, fmt.Errorf("ffprobe error: %v", err)
+	}
+
+	var probe videoProbe
+	if err := json.Unmarshal(stdout.Bytes(), &probe); err != nil {
+		return videoProbe{}, fmt.Errorf("could not parse ffprobe output: %v", err)
+	}
+	return probe, nil
+}
+
+// videoStream returns the first real video stream, skipping cover art
+func (p videoProbe) videoStream() (ffprobeStream, bool) {
+	for _, s := range p.Streams {
+		if s.CodecType == "video" && s.Disposition.AttachedPic == 0 {
+			return s, true
+		}
+	}
+	return ffprobeStream{}, false
+}
+
+func (p videoProbe) audioStream() (ffprobeStream, bool) {
+	for _, s := range p.Streams {
+		if s.CodecType == "audio" {
+			return s, true
+		}
+	}
+	return ffprobeStream{}, false
+}
+
+func (p videoProbe) duration() time.Duration {
+	seconds, err := strconv.ParseFloat(p.Format.Duration, 64)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// rotation is the clockwise display rotation in degrees, normalized to 0, 90, 180 or 270.
+// Newer ffmpeg reports it as display matrix side data, older as a rotate tag.
+func (s ffprobeStream) rotation() int {
+	degrees := 0.0
+	for _, sd := range s.SideDataList {
+		if sd.Rotation != nil {
+			// The display matrix rotation is counter-clockwise
+			degrees = -*sd.Rotation
+			break
+		}
+	}
+	if degrees == 0 && s.Tags.Rotate != "" {
+		if tag, err := strconv.ParseFloat(s.Tags.Rotate, 64); err == nil {
+			degrees = tag
+		}
+	}
+	normalized := int(math.Round(degrees/90)) * 90 % 360
+	if normalized < 0 {
+		normalized += 360
+	}
+	return normalized
+}
+
+// metadata turns the probe into the columns stored on the video
+func (p videoProbe) metadata() database.MediaMetadata {
+	meta := database.MediaMetadata{}
+	if d := p.duration(); d > 0 {
+		seconds := d.Seconds()
+		meta.DurationSeconds = &seconds
+	}
+	if size, err := strconv.ParseInt(p.Format.Size, 10, 64); err == nil {
+		meta.FileSize = &size
+	}
+	if bitrate, err := strconv.ParseInt(p.Format.BitRate, 10, 64); err == nil {
+		meta.Bitrate = &bitrate
+	}
+
+	if v, ok := p.videoStream(); ok {
+		meta.Width = &v.Width
+		meta.Height = &v.Height
+		meta.VideoCodec = &v.CodecName
+		rotation := v.rotation()
+		meta.Rotation = &rotation
+		// avg_frame_rate is 0/0 for some streams, r_frame_rate is the fallback
+		if fps, ok := parseRational(v.AvgFrameRate); ok {
+			meta.FrameRate = &fps
+		} else if fps, ok := parseRational(v.RFrameRate); ok {
+			meta.FrameRate = &fps
+		}
+	}
+	if a, ok := p.audioStream(); ok {
+		meta.AudioCodec = &a.CodecName
+		meta.AudioChannels = &a.Channels
+	}
+	return meta
+}
+
+// parseRational parses ffprobe's "num/den" ratios (also "num:den"), rejecting zero
+func parseRational(s string) (float64, bool) {
+	num, den, ok := strings.Cut(s, "/")
+	if !ok {
+		num, den, ok = strings.Cut(s, ":")
+	}
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, false
+	}
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil || d == 0 || n == 0 {
+		return 0, false
+	}
+	return n / d, true
+}
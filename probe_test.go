@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// probeOutput is what ffprobe -show_format -show_streams reports, trimmed,
+// for a phone video shot in portrait with cover art attached
+const probeOutput = `{
+	"streams": [
+		{
+			"index": 0,
+			"codec_type": "video",
+			"codec_name": "mjpeg",
+			"width": 300,
+			"height": 300,
+			"disposition": {"attached_pic": 1}
+		},
+		{
+			"index": 1,
+			"codec_type": "video",
+			"codec_name": "h264",
+			"width": 1920,
+			"height": 1080,
+			"avg_frame_rate": "0/0",
+			"r_frame_rate": "30000/1001",
+			"side_data_list": [{"rotation": -90}],
+			"disposition": {"attached_pic": 0}
+		},
+		{
+			"index": 2,
+			"codec_type": "audio",
+			"codec_name": "aac",
+			"channels": 2
+		}
+	],
+	"format": {
+		"duration": "12.345000",
+		"size": "4567890",
+		"bit_rate": "2960000"
+	}
+}`
+
+func TestVideoProbeMetadata(t *testing.T) {
+	var probe videoProbe
+	if err := json.Unmarshal([]byte(probeOutput), &probe); err != nil {
+		t.Fatal(err)
+	}
+	meta := probe.metadata()
+
+	if meta.DurationSeconds == nil || *meta.DurationSeconds != 12.345 {
+		t.Errorf("duration = %v, want 12.345", meta.DurationSeconds)
+	}
+	if meta.FileSize == nil || *meta.FileSize != 4567890 {
+		t.Errorf("file size = %v", meta.FileSize)
+	}
+	if meta.Bitrate == nil || *meta.Bitrate != 2960000 {
+		t.Errorf("bitrate = %v", meta.Bitrate)
+	}
+	if meta.VideoCodec == nil || *meta.VideoCodec != "h264" {
+		t.Errorf("video codec = %v, want h264 rather than the cover art", meta.VideoCodec)
+	}
+	if *meta.Width != 1920 || *meta.Height != 1080 || *meta.Rotation != 90 {
+		t.Errorf("size %dx%d rotated %d, want the coded 1920x1080 rotated 90", *meta.Width, *meta.Height, *meta.Rotation)
+	}
+	if meta.FrameRate == nil || *meta.FrameRate < 29.97 || *meta.FrameRate > 29.98 {
+		t.Errorf("frame rate = %v, want r_frame_rate's 29.97", meta.FrameRate)
+	}
+	if meta.AudioCodec == nil || *meta.AudioCodec != "aac" || *meta.AudioChannels != 2 {
+		t.Errorf("audio = %v with %v channels, want stereo aac", meta.AudioCodec, meta.AudioChannels)
+	}
+}
+
+func TestVideoProbeMetadataWithoutStreams(t *testing.T) {
+	meta := videoProbe{}.metadata()
+	if meta.DurationSeconds != nil || meta.Width != nil || meta.VideoCodec != nil || meta.AudioCodec != nil {
+		t.Errorf("metadata of an empty probe = %+v, want every field nil", meta)
+	}
+}
+
+func TestParseRational(t *testing.T) {
+	tests := []struct {
+		s    string
+		want float64
+		ok   bool
+	}{
+		{"30/1", 30, true},
+		{"4:3", 4.0 / 3.0, true},
+		{"0/0", 0, false},
+		{"30/0", 0, false},
+		{"0:1", 0, false},
+		{"30", 0, false},
+		{"a/b", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseRational(tt.s)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("parseRational(%q) = %v, %v, want %v, %v", tt.s, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestStreamRotation(t *testing.T) {
+	tests := []struct {
+		stream string
+		want   int
+	}{
+		{`{}`, 0},
+		{`{"tags": {"rotate": "90"}}`, 90},
+		{`{"tags": {"rotate": "-90"}}`, 270},
+		{`{"side_data_list": [{"rotation": 90}]}`, 270},
+		{`{"side_data_list": [{"rotation": -180}]}`, 180},
+		// The display matrix wins over the tag
+		{`{"tags": {"rotate": "90"}, "side_data_list": [{"rotation": 90}]}`, 270},
+		{`{"side_data_list": [{"rotation": -89.9}]}`, 90},
+	}
+	for _, tt := range tests {
+		var stream ffprobeStream
+		if err := json.Unmarshal([]byte(tt.stream), &stream); err != nil {
+			t.Fatal(err)
+		}
+		if got := stream.rotation(); got != tt.want {
+			t.Errorf("rotation of %s = %d, want %d", tt.stream, got, tt.want)
+		}
+	}
+}
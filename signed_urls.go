@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"log"
+	"path"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// signsVideoURLs reports whether the video is only handed out with expiring URLs
+func (cfg *apiConfig) signsVideoURLs(video database.Video) bool {
+	return cfg.videoURLSigner != nil && video.Visibility == database.VideoVisibilityPrivate
+}
+
+// signVideoURLs swaps a private video's URLs for signed ones that expire after
+// signedURLTTL. The stored URLs stay the same, they're signed every time they're read.
+// A URL that can't be signed is left out rather than handed out plain.
+func (cfg *apiConfig) signVideoURLs(ctx context.Context, video database.Video) (database.Video, error) {
+	if !cfg.signsVideoURLs(video) {
+		return video, nil
+	}
+	if video.VideoURL != nil {
+		key, ok := storage.KeyFromURL(cfg.videoStore, *video.VideoURL)
+		if !ok {
+			log.Printf("Couldn't sign URL of video %s: %s isn't in the video store", video.ID, *video.VideoURL)
+			video.VideoURL = nil
+		} else {
+			signed, err := cfg.videoURLSigner.SignedURL(ctx, key, cfg.signedURLTTL)
+			if err != nil {
+				return video, err
+			}
+			video.VideoURL = &signed
+		}
+	}
+
+	var err error
+	if video.HLSURL, err = cfg.signManifestURL(ctx, video, video.HLSURL); err != nil {
+		return video, err
+	}
+	if video.DASHURL, err = cfg.signManifestURL(ctx, video, video.DASHURL); err != nil {
+		return video, err
+	}
+	return video, nil
+}
+
+// signManifestURL signs an HLS or DASH manifest URL for everything in the
+// manifest's directory, so it also covers the segments. Only CloudFront can
+// sign a prefix, with S3 presigned URLs private videos play from the MP4.
+func (cfg *apiConfig) signManifestURL(ctx context.Context, video database.Video, manifestURL *string) (*string, error) {
+	if manifestURL == nil {
+		return nil, nil
+	}
+	signer, ok := cfg.videoURLSigner.(storage.PrefixSigner)
+	if !ok {
+		return nil, nil
+	}
+	key, ok := storage.KeyFromURL(cfg.videoStore, *manifestURL)
+	if !ok {
+		log.Printf("Couldn't sign manifest of video %s: %s isn't in the video store", video.ID, *manifestURL)
+		return nil, nil
+	}
+	signed, err := signer.SignedPrefixURL(ctx, key, path.Dir(key), cfg.signedURLTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &signed, nil
+}
+
+// signedPath returns path with a signature that stands in for the JWT for ttl,
+// see auth.SignPath
+func (cfg *apiConfig) signedPath(path string, ttl time.Duration) string {
+	return path + "?" + auth.SignPath(path, cfg.jwtSecret, time.Now().Add(ttl)).Encode()
+}
+
+// streamURL is where the video plays through the API, see handlerVideoStream.
+// A <video> element can't send the JWT, so a private video's URL is signed
+// for signedURLTTL.
+func (cfg *apiConfig) streamURL(video database.Video) *string {
+	if video.VideoURL == nil {
+		return nil
+	}
+	path := "/api/videos/" + video.ID.String() + "/stream"
+	if video.Visibility == database.VideoVisibilityPrivate {
+		path = cfg.signedPath(path, cfg.signedURLTTL)
+	}
+	return &path
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// fakeSigner marks the URLs it signs instead of signing them
+type fakeSigner struct{}
+
+func (fakeSigner) SignedURL(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	return "https://signed.example.com/" + key + "?ttl=" + ttl.String(), nil
+}
+
+type fakePrefixSigner struct {
+	fakeSigner
+}
+
+func (fakePrefixSigner) SignedPrefixURL(ctx context.Context, key, prefix string, ttl time.Duration) (string, error) {
+	return "https://signed.example.com/" + key + "?prefix=" + prefix, nil
+}
+
+func TestSignVideoURLs(t *testing.T) {
+	store := storage.NewMemoryStore("https://bucket.example.com")
+	stored := func(key string) *string {
+		u := store.URL(key)
+		return &u
+	}
+	video := database.Video{
+		ID:       uuid.New(),
+		VideoURL: stored("videos/abc.mp4"),
+		HLSURL:   stored("videos/abc/hls/master.m3u8"),
+		DASHURL:  stored("videos/abc/dash/manifest.mpd"),
+	}
+	str := func(s *string) string {
+		if s == nil {
+			return "<nil>"
+		}
+		return *s
+	}
+
+	tests := []struct {
+		name       string
+		signer     storage.URLSigner
+		visibility database.VideoVisibility
+		videoURL   string
+		hlsURL     string
+		dashURL    string
+	}{
+		{
+			name:       "no signer",
+			visibility: database.VideoVisibilityPrivate,
+			videoURL:   *video.VideoURL, hlsURL: *video.HLSURL, dashURL: *video.DASHURL,
+		},
+		{
+			name:       "public",
+			signer:     fakePrefixSigner{},
+			visibility: database.VideoVisibilityPublic,
+			videoURL:   *video.VideoURL, hlsURL: *video.HLSURL, dashURL: *video.DASHURL,
+		},
+		{
+			name:       "unlisted",
+			signer:     fakePrefixSigner{},
+			visibility: database.VideoVisibilityUnlisted,
+			videoURL:   *video.VideoURL, hlsURL: *video.HLSURL, dashURL: *video.DASHURL,
+		},
+		{
+			name:       "private, presigned",
+			signer:     fakeSigner{},
+			visibility: database.VideoVisibilityPrivate,
+			videoURL:   "https://signed.example.com/videos/abc.mp4?ttl=1h0m0s",
+			hlsURL:     "<nil>",
+			dashURL:    "<nil>",
+		},
+		{
+			name:       "private, prefix signed",
+			signer:     fakePrefixSigner{},
+			visibility: database.VideoVisibilityPrivate,
+			videoURL:   "https://signed.example.com/videos/abc.mp4?ttl=1h0m0s",
+			hlsURL:     "https://signed.example.com/videos/abc/hls/master.m3u8?prefix=videos/abc/hls",
+			dashURL:    "https://signed.example.com/videos/abc/dash/manifest.mpd?prefix=videos/abc/dash",
+		},
+	}
+	for _, tt := range tests {
+		cfg := &apiConfig{videoStore: store, videoURLSigner: tt.signer, signedURLTTL: time.Hour}
+		v := video
+		v.Visibility = tt.visibility
+		got, err := cfg.signVideoURLs(context.Background(), v)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if str(got.VideoURL) != tt.videoURL || str(got.HLSURL) != tt.hlsURL || str(got.DASHURL) != tt.dashURL {
+			t.Errorf("%s: got %s, %s, %s\nwant %s, %s, %s", tt.name,
+				str(got.VideoURL), str(got.HLSURL), str(got.DASHURL), tt.videoURL, tt.hlsURL, tt.dashURL)
+		}
+	}
+}
+
+func TestSignVideoURLsLeavesOutForeignURLs(t *testing.T) {
+	cfg := &apiConfig{
+		videoStore:     storage.NewMemoryStore("https://bucket.example.com"),
+		videoURLSigner: fakePrefixSigner{},
+		signedURLTTL:   time.Hour,
+	}
+	elsewhere := "https://elsewhere.example.com/videos/abc.mp4"
+	manifest := "https://elsewhere.example.com/videos/abc/hls/master.m3u8"
+	video, err := cfg.signVideoURLs(context.Background(), database.Video{
+		VideoURL:          &elsewhere,
+		HLSURL:            &manifest,
+		CreateVideoParams: database.CreateVideoParams{Visibility: database.VideoVisibilityPrivate},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if video.VideoURL != nil || video.HLSURL != nil {
+		t.Errorf("URLs outside the store were handed out unsigned: %v, %v", video.VideoURL, video.HLSURL)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+// errUnsupportedMediaType means an upload's content isn't what it claims to be,
+// handlers answer it with a 415
+var errUnsupportedMediaType = errors.New("unsupported media type")
+
+// sniffImageType detects an image's type from its magic bytes and rewinds r
+func sniffImageType(r io.ReadSeeker) (string, error) {
+	header := make([]byte, 512)
+	n, err := io.ReadFull(r, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", fmt.Errorf("couldn't read file: %w", err)
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("couldn't rewind file: %w", err)
+	}
+	return http.DetectContentType(header[:n]), nil
+}
+
+// probeVideoType asks ffprobe what container the file is, returning its media type.
+// Files ffprobe can't read, or that have no video stream, are errUnsupportedMediaType.
+func probeVideoType(filePath string) (string, error) {
+	cmd := exec.Command("ffprobe",
+		"-v", "error",
+		"-print_format", "json",
+		"-show_entries", "format=format_name:format_tags=major_brand:stream=codec_type",
+		filePath,
+	)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			// Keep the staging path out of a message that goes back to the client
+			reason := strings.TrimSpace(strings.ReplaceAll(stderr.String(), filePath+": ", ""))
+			return "", fmt.Errorf("%w: not a readable video (%s)", errUnsupportedMediaType, reason)
+		}
+		return "", fmt.Errorf("ffprobe error: %v", err)
+	}
+
+	var output struct {
+		Streams []struct {
+			CodecType string `json:"codec_type"`
+		} `json:"streams"`
+		Format struct {
+			FormatName string `json:"format_name"`
+			Tags       struct {
+				MajorBrand string `json:"major_brand"`
+			} `json:"tags"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &output); err != nil {
+		return "", fmt.Errorf("could not parse ffprobe output: %v", err)
+	}
+
+	hasVideo := false
+	for _, s := range output.Streams {
+		if s.CodecType == "video" {
+			hasVideo = true
+			break
+		}
+	}
+	if !hasVideo {
+		return "", fmt.Errorf("%w: file has no video stream", errUnsupportedMediaType)
+	}
+
+	formats := strings.Split(output.Format.FormatName, ",")
+	switch {
+	case slices.Contains(formats, "mp4"):
+		// mov and mp4 share a demuxer, the brand tells them apart
+		if strings.TrimSpace(output.Format.Tags.MajorBrand) == "qt" {
+			return "video/quicktime", nil
+		}
+		return "video/mp4", nil
+	case slices.Contains(formats, "webm"):
+		return "video/webm", nil
+	case slices.Contains(formats, "avi"):
+		return "video/x-msvideo", nil
+	case slices.Contains(formats, "mpegts"):
+		return "video/mp2t", nil
+	}
+	return "", fmt.Errorf("%w: unrecognized container %q", errUnsupportedMediaType, output.Format.FormatName)
+}
+
+// checkDetectedType makes sure the sniffed type is the one the client declared
+func checkDetectedType(declared, detected string) error {
+	if declared != detected {
+		return fmt.Errorf("%w: file is %s, not %s", errUnsupportedMediaType, detected, declared)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"testing"
+)
+
+func TestSniffImageType(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	var pngData, jpegData bytes.Buffer
+	if err := png.Encode(&pngData, img); err != nil {
+		t.Fatal(err)
+	}
+	if err := jpeg.Encode(&jpegData, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"png", pngData.Bytes(), "image/png"},
+		{"jpeg", jpegData.Bytes(), "image/jpeg"},
+		{"script named .png", []byte("<script>alert(1)</script>"), "text/html; charset=utf-8"},
+	}
+	for _, tt := range tests {
+		r := bytes.NewReader(tt.data)
+		got, err := sniffImageType(r)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: sniffed %q, want %q", tt.name, got, tt.want)
+		}
+		rest, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(rest, tt.data) {
+			t.Errorf("%s: reader wasn't rewound", tt.name)
+		}
+	}
+}
+
+func TestSniffImageTypeEmpty(t *testing.T) {
+	if _, err := sniffImageType(bytes.NewReader(nil)); err == nil {
+		t.Error("an empty file was sniffed")
+	}
+}
+
+func TestCheckDetectedType(t *testing.T) {
+	if err := checkDetectedType("image/png", "image/png"); err != nil {
+		t.Errorf("matching types: %v", err)
+	}
+	if err := checkDetectedType("image/png", "image/jpeg"); !errors.Is(err, errUnsupportedMediaType) {
+		t.Errorf("mismatched types: error = %v, want errUnsupportedMediaType", err)
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/thumbnail"
+	"github.com/google/uuid"
+)
+
+const (
+	jobTypeGenerateThumbnail = "generate_thumbnail"
+	// the threshold ffmpeg's scene score has to pass to count as a cut
+	sceneChangeThreshold = 0.3
+)
+
+// thumbnailOptions picks the frame used as a thumbnail. With SceneDetection
+// the first frame after a cut is used, falling back to Timestamp.
+type thumbnailOptions struct {
+	Timestamp      time.Duration `json:"timestamp"`
+	SceneDetection bool          `json:"scene_detection"`
+}
+
+type generateThumbnailPayload struct {
+	VideoID uuid.UUID        `json:"video_id"`
+	Options thumbnailOptions `json:"options"`
+}
+
+// extractThumbnail grabs a single JPEG frame from the video and returns its path
+func extractThumbnail(ctx context.Context, filePath string, duration time.Duration, opts thumbnailOptions) (string, error) {
+	outPath := filePath + ".thumbnail.jpg"
+
+	if opts.SceneDetection {
+		err := runFFmpeg(ctx, duration, nil, "-y", "-i", filePath,
+			"-vf", fmt.Sprintf("select='gt(scene,%g)'", sceneChangeThreshold),
+			"-frames:v", "1", "-fps_mode", "vfr", "-q:v", "2", outPath)
+		if err == nil && fileHasData(outPath) {
+			return outPath, nil
+		}
+		// No cut found (or a filter error), a fixed timestamp still gives us something
+	}
+
+	// Don't seek past the end of short videos
+	at := opts.Timestamp
+	if duration > 0 && at >= duration {
+		at = duration / 2
+	}
+	err := runFFmpeg(ctx, duration, nil, "-y", "-ss", strconv.FormatFloat(at.Seconds(), 'f', 3, 64),
+		"-i", filePath, "-frames:v", "1", "-q:v", "2", outPath)
+	if err != nil {
+		return "", fmt.Errorf("error extracting thumbnail: %v", err)
+	}
+	if !fileHasData(outPath) {
+		os.Remove(outPath)
+		return "", fmt.Errorf("ffmpeg didn't produce a thumbnail frame")
+	}
+	return outPath, nil
+}
+
+func fileHasData(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Size() > 0
+}
+
+// storeThumbnail extracts a frame from the video file and stores its resized variants
+func (cfg *apiConfig) storeThumbnail(ctx context.Context, filePath string, duration time.Duration, opts thumbnailOptions) (string, database.ThumbnailSet, error) {
+	thumbnailPath, err := extractThumbnail(ctx, filePath, duration, opts)
+	if err != nil {
+		return "", nil, err
+	}
+	defer os.Remove(thumbnailPath)
+
+	f, err := os.Open(thumbnailPath)
+	if err != nil {
+		return "", nil, fmt.Errorf("couldn't open thumbnail: %w", err)
+	}
+	defer f.Close()
+
+	img, err := thumbnail.Decode(f)
+	if err != nil {
+		return "", nil, err
+	}
+	return cfg.storeThumbnailVariants(ctx, img)
+}
+
+// storeThumbnailVariants puts every resized variant of img in the asset store.
+// It returns the URL of the largest one, used as the video's ThumbnailURL, and the full set.
+func (cfg *apiConfig) storeThumbnailVariants(ctx context.Context, img image.Image) (string, database.ThumbnailSet, error) {
+	variants, err := thumbnail.Variants(img)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// All sizes share one random name so they're easy to spot together
+	assetPath := getAssetPath("image/jpeg")
+	base := strings.TrimSuffix(assetPath, path.Ext(assetPath))
+
+	thumbnails := database.ThumbnailSet{}
+	largest := ""
+	for _, v := range variants {
+		key := fmt.Sprintf("%s-%d.jpeg", base, v.Width)
+		if err := cfg.assetStore.Put(ctx, key, bytes.NewReader(v.Data), "image/jpeg"); err != nil {
+			return "", nil, fmt.Errorf("couldn't store thumbnail: %w", err)
+		}
+		largest = cfg.getAssetURL(key)
+		thumbnails[strconv.Itoa(v.Width)] = largest
+	}
+	return largest, thumbnails, nil
+}
+
+// deleteThumbnails removes a video's thumbnail and its variants from the asset store.
+// It's best effort, an old thumbnail left behind only costs storage.
+func (cfg *apiConfig) deleteThumbnails(ctx context.Context, thumbnailURL *string, thumbnails database.ThumbnailSet) {
+	for _, key := range cfg.thumbnailKeys(thumbnailURL, thumbnails) {
+		if err := cfg.assetStore.Delete(ctx, key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("Couldn't delete old thumbnail %s: %v", key, err)
+		}
+	}
+}
+
+// thumbnailKeys returns the asset store keys of a thumbnail and its variants,
+// skipping URLs the store didn't hand out
+func (cfg *apiConfig) thumbnailKeys(thumbnailURL *string, thumbnails database.ThumbnailSet) []string {
+	urls := []string{}
+	if thumbnailURL != nil {
+		urls = append(urls, *thumbnailURL)
+	}
+	for _, url := range thumbnails {
+		if thumbnailURL == nil || url != *thumbnailURL {
+			urls = append(urls, url)
+		}
+	}
+
+	keys := []string{}
+	for _, url := range urls {
+		if key, ok := storage.KeyFromURL(cfg.assetStore, url); ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// autoThumbnail gives a freshly processed video a thumbnail if the user hasn't uploaded one.
+// It's best effort: a video without a thumbnail is still a usable video.
+func (cfg *apiConfig) autoThumbnail(ctx context.Context, video database.Video, filePath string, duration time.Duration) {
+	// video is from when processing started, the user may have uploaded one since
+	current, err := cfg.db.GetVideoWithTrashed(video.ID)
+	if err != nil {
+		log.Printf("Couldn't get video %s: %v", video.ID, err)
+		return
+	}
+	if current.ID == uuid.Nil || current.ThumbnailURL != nil {
+		return
+	}
+	url, thumbnails, err := cfg.storeThumbnail(ctx, filePath, duration, cfg.thumbnailOptions)
+	if err != nil {
+		log.Printf("Couldn't generate thumbnail for video %s: %v", video.ID, err)
+		return
+	}
+	// Or while ffmpeg was running. Nothing else writes the thumbnail columns
+	// of the row, so if theirs is there, theirs wins.
+	set, err := cfg.db.SetVideoThumbnailIfMissing(video.ID, url, thumbnails, "image/jpeg")
+	if err != nil {
+		log.Printf("Couldn't save thumbnail for video %s: %v", video.ID, err)
+	}
+	if !set {
+		cfg.deleteThumbnails(ctx, &url, thumbnails)
+	}
+}
+
+// handleGenerateThumbnailJob regenerates a thumbnail from the stored video, replacing the current one
+func (cfg *apiConfig) handleGenerateThumbnailJob(ctx context.Context, job database.Job) error {
+	var payload generateThumbnailPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		return fmt.Errorf("%w: invalid payload: %v", jobs.ErrPermanent, err)
+	}
+
+	video, err := cfg.db.GetVideoWithTrashed(payload.VideoID)
+	if err != nil {
+		return fmt.Errorf("couldn't find video: %w", err)
+	}
+	if video.ID == uuid.Nil || video.VideoURL == nil {
+		return fmt.Errorf("%w: video %s has no video file", jobs.ErrPermanent, payload.VideoID)
+	}
+	key, ok := storage.KeyFromURL(cfg.videoStore, *video.VideoURL)
+	if !ok {
+		return fmt.Errorf("%w: video URL %s isn't in the video store", jobs.ErrPermanent, *video.VideoURL)
+	}
+
+	filePath, err := cfg.downloadVideo(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(filePath)
+
+	duration, err := getVideoDuration(filePath)
+	if err != nil {
+		return fmt.Errorf("couldn't determine duration: %w", err)
+	}
+	url, thumbnails, err := cfg.storeThumbnail(ctx, filePath, duration, payload.Options)
+	if err != nil {
+		return err
+	}
+
+	// Only the thumbnail columns are written, so changes made while ffmpeg
+	// was running aren't overwritten
+	oldURL, oldThumbnails, err := cfg.db.ReplaceVideoThumbnail(payload.VideoID, url, thumbnails, "image/jpeg")
+	if errors.Is(err, database.ErrVideoNotFound) {
+		cfg.deleteThumbnails(ctx, &url, thumbnails)
+		return fmt.Errorf("%w: video %s no longer exists", jobs.ErrPermanent, payload.VideoID)
+	}
+	if err != nil {
+		cfg.deleteThumbnails(ctx, &url, thumbnails)
+		return err
+	}
+
+	// The replaced thumbnail isn't referenced anymore
+	cfg.deleteThumbnails(ctx, oldURL, oldThumbnails)
+	return nil
+}
+
+// downloadVideo copies a stored video into the upload directory so ffmpeg can seek in it
+func (cfg *apiConfig) downloadVideo(ctx context.Context, key string) (string, error) {
+	body, _, err := cfg.videoStore.Get(ctx, key)
+	if err != nil {
+		return "", fmt.Errorf("couldn't get video from storage: %w", err)
+	}
+	defer body.Close()
+
+	f, err := os.CreateTemp(cfg.uploadDir, "download-*"+filepath.Ext(key))
+	if err != nil {
+		return "", fmt.Errorf("couldn't create temp file: %w", err)
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, body); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("couldn't download video: %w", err)
+	}
+	return f.Name(), nil
+}
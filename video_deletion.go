@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+const (
+	jobTypeDeleteVideoObjects = "delete_video_objects"
+	// Deleting is idempotent, so it's worth a few more tries than processing
+	deleteVideoObjectsMaxAttempts = 5
+)
+
+// Names of the blob stores, as recorded in jobs and failed deletions
+const (
+	storeNameVideo = "video"
+	storeNameAsset = "asset"
+)
+
+// storedObject is a key in one of the blob stores. A key ending in a slash
+// stands for everything under that prefix.
+type storedObject struct {
+	Store string `json:"store"`
+	Key   string `json:"key"`
+}
+
+func (o storedObject) isPrefix() bool {
+	return strings.HasSuffix(o.Key, "/")
+}
+
+type deleteVideoObjectsPayload struct {
+	VideoID uuid.UUID      `json:"video_id"`
+	Objects []storedObject `json:"objects"`
+}
+
+func (cfg *apiConfig) blobStore(name string) (storage.BlobStore, bool) {
+	switch name {
+	case storeNameVideo:
+		return cfg.videoStore, true
+	case storeNameAsset:
+		return cfg.assetStore, true
+	}
+	return nil, false
+}
+
+// videoObjects lists everything stored for a video: the MP4, the prefix its HLS
+// and DASH renditions live under, and the thumbnail with its variants
+func (cfg *apiConfig) videoObjects(video database.Video) []storedObject {
+	objects := []storedObject{}
+	seen := map[storedObject]bool{}
+	add := func(object storedObject) {
+		if !seen[object] {
+			seen[object] = true
+			objects = append(objects, object)
+		}
+	}
+
+	if video.VideoURL != nil {
+		if key, ok := storage.KeyFromURL(cfg.videoStore, *video.VideoURL); ok {
+			add(storedObject{storeNameVideo, key})
+			// packageHLS and packageDASH write next to the MP4 under its name
+			add(storedObject{storeNameVideo, strings.TrimSuffix(key, path.Ext(key)) + "/"})
+		}
+	}
+	for _, url := range []*string{video.HLSURL, video.DASHURL} {
+		if url == nil {
+			continue
+		}
+		if key, ok := storage.KeyFromURL(cfg.videoStore, *url); ok {
+			add(storedObject{storeNameVideo, key})
+		}
+	}
+	for _, key := range cfg.thumbnailKeys(video.ThumbnailURL, video.Thumbnails) {
+		add(storedObject{storeNameAsset, key})
+	}
+	return objects
+}
+
+// enqueueVideoObjectDeletion queues the removal of everything stored for a video
+// that's already gone from the database. If even that fails, the objects are
+// recorded as failed deletions so they aren't forgotten.
+func (cfg *apiConfig) enqueueVideoObjectDeletion(video database.Video) {
+	objects := cfg.videoObjects(video)
+	if len(objects) == 0 {
+		return
+	}
+
+	_, err := cfg.jobs.Enqueue(jobTypeDeleteVideoObjects, video.UserID, deleteVideoObjectsPayload{
+		VideoID: video.ID,
+		Objects: objects,
+	}, deleteVideoObjectsMaxAttempts)
+	if err != nil {
+		log.Printf("Couldn't queue deletion of video %s's objects: %v", video.ID, err)
+		for _, object := range objects {
+			cfg.recordFailedDeletion(video.ID, object, err)
+		}
+	}
+}
+
+func (cfg *apiConfig) handleDeleteVideoObjectsJob(ctx context.Context, job database.Job) error {
+	var payload deleteVideoObjectsPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		return fmt.Errorf("%w: invalid payload: %v", jobs.ErrPermanent, err)
+	}
+
+	failed := map[storedObject]error{}
+	var firstErr error
+	for _, object := range payload.Objects {
+		if err := cfg.deleteStoredObject(ctx, object); err != nil {
+			failed[object] = err
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+
+	err := fmt.Errorf("couldn't delete %d of %d objects: %w", len(failed), len(payload.Objects), firstErr)
+	if !jobs.WillRetry(job, err) {
+		for object, objectErr := range failed {
+			cfg.recordFailedDeletion(payload.VideoID, object, objectErr)
+		}
+	}
+	return err
+}
+
+// deleteStoredObject deletes an object, or every object under a prefix, and
+// returns the first error. Objects that are already gone count as deleted.
+func (cfg *apiConfig) deleteStoredObject(ctx context.Context, object storedObject) error {
+	store, ok := cfg.blobStore(object.Store)
+	if !ok {
+		return fmt.Errorf("unknown blob store %q", object.Store)
+	}
+
+	keys := []string{object.Key}
+	if object.isPrefix() {
+		infos, err := store.List(ctx, object.Key)
+		if err != nil {
+			return fmt.Errorf("couldn't list %s: %w", object.Key, err)
+		}
+		keys = keys[:0]
+		for _, info := range infos {
+			keys = append(keys, info.Key)
+		}
+	}
+
+	// One stuck key shouldn't keep the rest of a prefix around
+	var firstErr error
+	for _, key := range keys {
+		if err := store.Delete(ctx, key); err != nil && !errors.Is(err, storage.ErrNotFound) && firstErr == nil {
+			firstErr = fmt.Errorf("couldn't delete %s: %w", key, err)
+		}
+	}
+	return firstErr
+}
+
+// recordFailedDeletion leaves a note of an object that's still stored so a later cleanup can retry it
+func (cfg *apiConfig) recordFailedDeletion(videoID uuid.UUID, object storedObject, cause error) {
+	log.Printf("Giving up on deleting %s from the %s store: %v", object.Key, object.Store, cause)
+	if err := cfg.db.RecordFailedDeletion(object.Store, object.Key, videoID, cause.Error()); err != nil {
+		log.Printf("Couldn't record failed deletion of %s: %v", object.Key, err)
+	}
+}
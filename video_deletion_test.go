@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// failingDeleteStore is a BlobStore that can't delete one key
+type failingDeleteStore struct {
+	storage.BlobStore
+	key string
+}
+
+func (s failingDeleteStore) Delete(ctx context.Context, key string) error {
+	if key == s.key {
+		return errors.New("access denied")
+	}
+	return s.BlobStore.Delete(ctx, key)
+}
+
+func TestVideoObjects(t *testing.T) {
+	cfg := &apiConfig{
+		videoStore: storage.NewMemoryStore("http://localhost:8091/videos"),
+		assetStore: storage.NewMemoryStore("http://localhost:8091/assets"),
+	}
+	videoURL := cfg.videoStore.URL("landscape/abc.mp4")
+	hlsURL := cfg.videoStore.URL("landscape/abc/hls/master.m3u8")
+	foreignURL := "https://elsewhere.example.com/landscape/abc/dash/manifest.mpd"
+	thumbnailURL := cfg.assetStore.URL("thumbnails/t-1280.jpg")
+	video := database.Video{
+		VideoURL:     &videoURL,
+		HLSURL:       &hlsURL,
+		DASHURL:      &foreignURL,
+		ThumbnailURL: &thumbnailURL,
+		Thumbnails: database.ThumbnailSet{
+			"160":  cfg.assetStore.URL("thumbnails/t-160.jpg"),
+			"1280": thumbnailURL,
+		},
+	}
+
+	got := cfg.videoObjects(video)
+	want := []storedObject{
+		{storeNameVideo, "landscape/abc.mp4"},
+		{storeNameVideo, "landscape/abc/"},
+		{storeNameVideo, "landscape/abc/hls/master.m3u8"},
+		{storeNameAsset, "thumbnails/t-1280.jpg"},
+		{storeNameAsset, "thumbnails/t-160.jpg"},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("videoObjects = %v, want %v", got, want)
+	}
+	if got := cfg.videoObjects(database.Video{}); len(got) != 0 {
+		t.Errorf("a video without files has objects %v", got)
+	}
+}
+
+func TestHandleDeleteVideoObjectsJob(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name     string
+		attempts int
+		recorded bool
+	}{
+		{"retried", 1, false},
+		{"out of attempts", deleteVideoObjectsMaxAttempts, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			videoStore := storage.NewMemoryStore("http://localhost:8091/videos")
+			cfg := &apiConfig{
+				db:         newTestDB(t),
+				videoStore: failingDeleteStore{videoStore, "landscape/abc/dash/manifest.mpd"},
+				assetStore: storage.NewMemoryStore("http://localhost:8091/assets"),
+			}
+			for _, key := range []string{
+				"landscape/abc.mp4",
+				"landscape/abc/hls/master.m3u8",
+				"landscape/abc/dash/manifest.mpd",
+				"landscape/other.mp4",
+			} {
+				if err := videoStore.Put(ctx, key, strings.NewReader(key), "application/octet-stream"); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			videoID := uuid.New()
+			payload, err := json.Marshal(deleteVideoObjectsPayload{
+				VideoID: videoID,
+				Objects: []storedObject{
+					{storeNameVideo, "landscape/abc.mp4"},
+					{storeNameVideo, "landscape/abc/"},
+					{storeNameAsset, "thumbnails/already-gone.jpg"},
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			job := database.Job{
+				Attempts:        tt.attempts,
+				CreateJobParams: database.CreateJobParams{Payload: string(payload), MaxAttempts: deleteVideoObjectsMaxAttempts},
+			}
+
+			if err := cfg.handleDeleteVideoObjectsJob(ctx, job); err == nil || !strings.Contains(err.Error(), "access denied") {
+				t.Errorf("error = %v, want the failed delete", err)
+			}
+			objects, err := videoStore.List(ctx, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var keys []string
+			for _, object := range objects {
+				keys = append(keys, object.Key)
+			}
+			slices.Sort(keys)
+			if want := []string{"landscape/abc/dash/manifest.mpd", "landscape/other.mp4"}; !slices.Equal(keys, want) {
+				t.Errorf("left %v, want %v", keys, want)
+			}
+
+			failed, err := cfg.db.GetFailedDeletions(10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.recorded {
+				if len(failed) != 0 {
+					t.Errorf("recorded %+v while the job will be retried", failed)
+				}
+				return
+			}
+			if len(failed) != 1 || failed[0].Store != storeNameVideo || failed[0].Key != "landscape/abc/" || failed[0].VideoID != videoID {
+				t.Errorf("failed deletions = %+v, want the HLS and DASH prefix", failed)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/progress"
+	"github.com/google/uuid"
+)
+
+const (
+	jobTypeProcessVideo = "process_video"
+	// ffmpeg failures are usually deterministic, but S3 hiccups aren't
+	processVideoMaxAttempts = 3
+)
+
+type processVideoPayload struct {
+	VideoID   uuid.UUID `json:"video_id"`
+	FilePath  string    `json:"file_path"`
+	MediaType string    `json:"media_type"`
+}
+
+func (cfg *apiConfig) registerJobHandlers() {
+	cfg.jobs.Handle(jobTypeProcessVideo, cfg.handleProcessVideoJob)
+	cfg.jobs.Handle(jobTypeGenerateThumbnail, cfg.handleGenerateThumbnailJob)
+	cfg.jobs.Handle(jobTypeDeleteVideoObjects, cfg.handleDeleteVideoObjectsJob)
+}
+
+// enqueueVideoProcessing moves the video to processing and queues a staged
+// upload for processing. The job takes ownership of the file at filePath.
+func (cfg *apiConfig) enqueueVideoProcessing(video database.Video, filePath, mediaType string) (database.Job, error) {
+	err := cfg.db.UpdateVideoStatus(video.ID, database.VideoStatusProcessing, "")
+	if err != nil {
+		return database.Job{}, err
+	}
+
+	job, err := cfg.jobs.Enqueue(jobTypeProcessVideo, video.UserID, processVideoPayload{
+		VideoID:   video.ID,
+		FilePath:  filePath,
+		MediaType: mediaType,
+	}, processVideoMaxAttempts)
+	if err != nil {
+		cfg.markVideoFailed(video.ID, "couldn't queue video processing")
+		return database.Job{}, err
+	}
+	cfg.progress.Publish(progress.Event{VideoID: video.ID, Stage: progress.StageQueued})
+	return job, nil
+}
+
+func (cfg *apiConfig) handleProcessVideoJob(ctx context.Context, job database.Job) (err error) {
+	var payload processVideoPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		return fmt.Errorf("%w: invalid payload: %v", jobs.ErrPermanent, err)
+	}
+
+	tracker := cfg.progress.Track(payload.VideoID)
+
+	// The staging file is only needed until the last attempt
+	defer func() {
+		if jobs.WillRetry(job, err) {
+			tracker.Stage(progress.StageQueued)
+			return
+		}
+		if err != nil {
+			cfg.markVideoFailed(payload.VideoID, err.Error())
+		}
+		tracker.Done(err)
+		if err := os.Remove(payload.FilePath); err != nil && !os.IsNotExist(err) {
+			log.Printf("Couldn't remove staging file %s: %v", payload.FilePath, err)
+		}
+	}()
+
+	video, err := cfg.db.GetVideoWithTrashed(payload.VideoID)
+	if err != nil {
+		return fmt.Errorf("couldn't find video: %w", err)
+	}
+	if video.ID == uuid.Nil {
+		return fmt.Errorf("%w: video %s no longer exists", jobs.ErrPermanent, payload.VideoID)
+	}
+
+	processed, err := cfg.processVideoUpload(ctx, video, payload.FilePath, payload.MediaType, tracker)
+	if err != nil {
+		return err
+	}
+	current, err := cfg.db.GetVideoWithTrashed(video.ID)
+	if err == nil && current.ID == uuid.Nil {
+		// Purged while processing, nothing will ever reference what was just stored
+		cfg.enqueueVideoObjectDeletion(processed)
+		return nil
+	}
+	return cfg.db.UpdateVideoStatus(video.ID, database.VideoStatusReady, "")
+}
+
+func (cfg *apiConfig) markVideoFailed(videoID uuid.UUID, reason string) {
+	err := cfg.db.UpdateVideoStatus(videoID, database.VideoStatusFailed, reason)
+	if err != nil {
+		log.Printf("Couldn't mark video %s as failed: %v", videoID, err)
+	}
+}